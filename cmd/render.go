@@ -4,8 +4,9 @@ Copyright © 2022 Seb P sebpsdev@gmail.com
 package cmd
 
 import (
+	"bufio"
+	"encoding/json"
 	"errors"
-	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -18,6 +19,9 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// Extension appended to the path of an output to write its missing variables report
+const MISSING_VARIABLES_REPORT_EXTENSION = ".missing.json"
+
 func renderAndWrite(
 	template string,
 	variablesSets []map[string]interface{},
@@ -30,8 +34,54 @@ func renderAndWrite(
 	isMultipleOutput bool,
 	multipleOutputFilenamePattern string,
 	panicIfNoMatch bool,
+	panicIfMissingLoop bool,
+	missingVariablesReport bool,
+	escaping string,
+	structured string,
+	loader rendering.Loader,
+	keyOrder rendering.KeyOrder,
 	path string,
-) {
+	name string,
+) error {
+	if escaping == rendering.AutoEscaping {
+		escaping = rendering.EscapingFromExtension(path)
+	}
+	if structured == rendering.AutoStructured {
+		structured = rendering.StructuredFromExtension(path)
+	}
+
+	// the template is compiled once for all the variables sets
+	compiled, err := rendering.Compile(template, rendering.Options{
+		Name:                       name,
+		LeftDelimiter:              leftDelimiter,
+		RightDelimiter:             rightDelimiter,
+		LeftLoopVariableDelimiter:  leftLoopVariableDelimiter,
+		RightLoopVariableDelimiter: rightLoopVariableDelimiter,
+		LeftLoopBlockDelimiter:     leftLoopBlockDelimiter,
+		RightLoopBlockDelimiter:    rightLoopBlockDelimiter,
+		PanicIfNoMatch:             panicIfNoMatch,
+		PanicIfMissingLoop:         panicIfMissingLoop,
+		Escaping:                   escaping,
+		Structured:                 structured,
+		Loader:                     loader,
+	})
+	if err != nil {
+		return err
+	}
+
+	// the file name pattern is compiled once as well, its placeholders being delimited by braces
+	var filenamePattern *rendering.Template
+	if isMultipleOutput {
+		filenamePattern, err = rendering.Compile(multipleOutputFilenamePattern, rendering.Options{
+			Name:           "multiple output filename pattern",
+			LeftDelimiter:  "{",
+			RightDelimiter: "}",
+		})
+		if err != nil {
+			return err
+		}
+	}
+
 	for i, variables := range variablesSets {
 		currentPathOut := path
 
@@ -40,10 +90,18 @@ func renderAndWrite(
 			currentPathExtension := filepath.Ext(path)
 			currentPathBase := filepath.Base(path)
 			currentPathBase = strings.Replace(currentPathBase, currentPathExtension, "", 1)
-			currentPathBase = strings.ReplaceAll(multipleOutputFilenamePattern, "{0}", currentPathBase)
-			currentPathBase = strings.ReplaceAll(currentPathBase, "{i}", fmt.Sprint(strconv.Itoa(i)))
-			currentPathBase, _, success := rendering.Interpolate(currentPathBase, variables, "{", "}", false)
-			if !success {
+			// the file name and index are interpolated with the data in a single pass, none of the values being expanded again
+			patternVariables := make(map[string]interface{}, len(variables)+2)
+			for key, value := range variables {
+				patternVariables[key] = value
+			}
+			patternVariables["0"] = currentPathBase
+			patternVariables["i"] = strconv.Itoa(i)
+			currentPathBase, report, err := filenamePattern.ExecuteReport(patternVariables)
+			if err != nil {
+				return err
+			}
+			if len(report.Missing) > 0 {
 				// if path interpolation failed skip current variable set
 				continue
 			}
@@ -52,35 +110,60 @@ func renderAndWrite(
 			currentPathOut = filepath.Join(currentPathDir, currentPathBase)
 		}
 
-		rendered := rendering.Render(
-			template,
-			variables,
-			leftDelimiter,
-			rightDelimiter,
-			leftLoopVariableDelimiter,
-			rightLoopVariableDelimiter,
-			leftLoopBlockDelimiter,
-			rightLoopBlockDelimiter,
-			panicIfNoMatch,
-		)
-
-		err := utils.WriteFileContent(currentPathOut, rendered)
+		// the output is written as it is rendered, and removed when the rendering fails
+		file, err := utils.CreateFile(currentPathOut)
 		if err != nil {
 			panic(err)
 		}
+		writer := bufio.NewWriter(file)
+		report, err := compiled.ExecuteTo(writer, variables, keyOrder)
+		if err == nil {
+			err = writer.Flush()
+		}
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(currentPathOut)
+		}
+
+		if missingVariablesReport && report != nil {
+			// the report is written even when the rendering fails because of the missing variables
+			encodedReport, err := json.MarshalIndent(report, "", "  ")
+			if err != nil {
+				return err
+			}
+			if err := utils.WriteFileContent(currentPathOut+MISSING_VARIABLES_REPORT_EXTENSION, string(encodedReport)); err != nil {
+				panic(err)
+			}
+		}
+		if err != nil {
+			return err
+		}
 	}
+
+	return nil
 }
 
 var renderCmd = &cobra.Command{
 	Use:   "render",
 	Short: "Render a single file or a full directory",
 	Long:  "Render a single file or a full directory",
-	Run: func(cmd *cobra.Command, args []string) {
+	// the rendering errors are located in the templates, the usage does not help fixing them
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
 		in, _ := cmd.Flags().GetString("in")
 		out, _ := cmd.Flags().GetString("out")
 		dataPath, _ := cmd.Flags().GetString("data")
 		dataFilter, _ := cmd.Flags().GetString("data-filter")
-		panicIfNoMatch, _ := cmd.Flags().GetBool("panic-if-no-match")
+		panicIfNoMatchFlag, _ := cmd.Flags().GetString("panic-if-no-match")
+		panicIfMissingLoopFlag, _ := cmd.Flags().GetString("panic-if-missing-loop")
+		missingVariablesReportFlag, _ := cmd.Flags().GetString("missing-variables-report")
+		escaping, _ := cmd.Flags().GetString("escaping")
+		structured, _ := cmd.Flags().GetString("structured-output")
+		partials, _ := cmd.Flags().GetString("partials")
+		loopOrder, _ := cmd.Flags().GetString("loop-order")
+		streamFlag, _ := cmd.Flags().GetString("stream")
 		leftDelimiter, _ := cmd.Flags().GetString("left-delimiter")
 		rightDelimiter, _ := cmd.Flags().GetString("right-delimiter")
 		leftLoopVariableDelimiter, _ := cmd.Flags().GetString("left-loop-variable-delimiter")
@@ -99,6 +182,11 @@ var renderCmd = &cobra.Command{
 			isMultipleOutput = false
 		}
 
+		stream := streamFlag == "true"
+		panicIfNoMatch := panicIfNoMatchFlag == "true"
+		panicIfMissingLoop := panicIfMissingLoopFlag == "true"
+		missingVariablesReport := missingVariablesReportFlag == "true"
+
 		/* rules start */
 		inFileInfo, err := os.Stat(in)
 		if err != nil {
@@ -117,19 +205,68 @@ var renderCmd = &cobra.Command{
 				panic(errors.New("wrong data filter format"))
 			}
 		}
+
+		if stream && (isMultipleOutput || dataFilter != "") {
+			return errors.New("streaming requires a single output and no data filter")
+		}
+
+		if loopOrder != rendering.SortedKeyOrder && loopOrder != rendering.InsertionKeyOrder {
+			return &rendering.InvalidOptionError{Option: "loop order", Value: loopOrder, Reason: "must be sorted or insertion"}
+		}
+		if loopOrder == rendering.InsertionKeyOrder && (filepath.Ext(dataPath) != ".json" || stream) {
+			return &rendering.InvalidOptionError{Option: "loop order", Value: loopOrder, Reason: "requires a json data file, without streaming"}
+		}
 		/* rules end */
 
-		variables, err := parsing.ParseVariablesFile(dataPath, dataFilter, keyColumn, isMultipleOutput, loopVariable)
-		if err != nil {
-			panic(err)
+		// the included templates are searched in the input directory, then in the partials directories
+		inputDir := in
+		if !inFileInfo.IsDir() {
+			inputDir = filepath.Dir(in)
+		}
+		partialsDirs := []string{}
+		if partials != "" {
+			for _, partialsDir := range strings.Split(partials, ",") {
+				partialsDirs = append(partialsDirs, strings.TrimSpace(partialsDir))
+			}
+		}
+		loader := rendering.NewDirectoryLoader(append([]string{inputDir}, partialsDirs...)...)
+
+		var variables []map[string]interface{}
+		var keyOrder rendering.KeyOrder
+		if stream {
+			// the records are read from the data file as they are rendered, under the root loop variable
+			records, err := parsing.OpenRecords(dataPath, keyColumn)
+			if err != nil {
+				return err
+			}
+			variables = []map[string]interface{}{{loopVariable: records}}
+		} else if loopOrder == rendering.InsertionKeyOrder {
+			// the maps of a JSON data file are iterated in the order of their keys in the file
+			orderedVariables, order, err := parsing.ParseOrderedVariablesFile(dataPath, dataFilter, isMultipleOutput, loopVariable)
+			if err != nil {
+				panic(err)
+			}
+			variables, keyOrder = orderedVariables, order
+		} else {
+			variables, err = parsing.ParseVariablesFile(dataPath, dataFilter, keyColumn, isMultipleOutput, loopVariable)
+			if err != nil {
+				panic(err)
+			}
 		}
 
 		if inFileInfo.IsDir() {
-			filepath.Walk(in, func(pathIn string, info os.FileInfo, err error) error {
+			return filepath.Walk(in, func(pathIn string, info os.FileInfo, err error) error {
 				if err != nil {
 					panic(err)
 				}
 				if info.IsDir() {
+					// the partials are only rendered through the templates including them
+					absolutePathIn, _ := filepath.Abs(pathIn)
+					for _, partialsDir := range partialsDirs {
+						if absolutePartialsDir, _ := filepath.Abs(partialsDir); absolutePathIn == absolutePartialsDir {
+							return filepath.SkipDir
+						}
+					}
 					return nil
 				}
 
@@ -141,7 +278,7 @@ var renderCmd = &cobra.Command{
 					panic(err)
 				}
 
-				renderAndWrite(
+				return renderAndWrite(
 					template,
 					variables,
 					leftDelimiter,
@@ -153,10 +290,15 @@ var renderCmd = &cobra.Command{
 					isMultipleOutput,
 					multipleOutputFilenamePattern,
 					panicIfNoMatch,
+					panicIfMissingLoop,
+					missingVariablesReport,
+					escaping,
+					structured,
+					loader,
+					keyOrder,
 					pathOut,
+					pathIn,
 				)
-
-				return nil
 			})
 		} else {
 			template, err := utils.ReadFileContent(in)
@@ -164,7 +306,7 @@ var renderCmd = &cobra.Command{
 				panic(err)
 			}
 
-			renderAndWrite(
+			return renderAndWrite(
 				template,
 				variables,
 				leftDelimiter,
@@ -176,7 +318,14 @@ var renderCmd = &cobra.Command{
 				isMultipleOutput,
 				multipleOutputFilenamePattern,
 				panicIfNoMatch,
+				panicIfMissingLoop,
+				missingVariablesReport,
+				escaping,
+				structured,
+				loader,
+				keyOrder,
 				out,
+				in,
 			)
 		}
 	},
@@ -191,15 +340,22 @@ func init() {
 	renderCmd.Flags().StringP("data-filter", "f", "", "JSONPath filtering expression on data to reduce the input data before rendering")
 	renderCmd.Flags().StringP("left-delimiter", "l", "{{", "Left variable delimiter ( default is {{ )")
 	renderCmd.Flags().StringP("right-delimiter", "r", "}}", "Right variable delimiter ( default is }} )")
-	renderCmd.Flags().StringP("left-loop-variable-delimiter", "", "(", "Left loop variable delimiter ( default is '(' )")
-	renderCmd.Flags().StringP("right-loop-variable-delimiter", "", ")", "Right loop variable delimiter ( default is ')' )")
-	renderCmd.Flags().StringP("left-loop-block-delimiter", "", "[", "Left loop block delimiter ( default is '[' )")
-	renderCmd.Flags().StringP("right-loop-block-delimiter", "", "]", "Right loop block delimiter ( default is ']' )")
-	renderCmd.Flags().StringP("panic-if-no-match", "p", "true", "Panic if a variable is not found in the data")
+	renderCmd.Flags().StringP("left-loop-variable-delimiter", "", "(", "Left loop variable and condition delimiter ( default is '(' )")
+	renderCmd.Flags().StringP("right-loop-variable-delimiter", "", ")", "Right loop variable and condition delimiter ( default is ')' )")
+	renderCmd.Flags().StringP("left-loop-block-delimiter", "", "[", "Left loop and condition block delimiter ( default is '[' )")
+	renderCmd.Flags().StringP("right-loop-block-delimiter", "", "]", "Right loop and condition block delimiter ( default is ']' )")
+	renderCmd.Flags().StringP("panic-if-no-match", "p", "false", "Panic if a variable without fallback ( {{name ?? \"default\"}} ) is not found in the data, {{name!}} always panicking ( default is 'false' )")
+	renderCmd.Flags().StringP("panic-if-missing-loop", "", "false", "Panic if the variable of a loop block without (else)[ ] branch is not found in the data ( default is 'false' )")
+	renderCmd.Flags().StringP("missing-variables-report", "", "false", "Whether to write the variables not found in the data as JSON next to each output ( <output>"+MISSING_VARIABLES_REPORT_EXTENSION+" ) ( default is 'false' )")
+	renderCmd.Flags().StringP("escaping", "", rendering.NoEscaping, "Escaping of the inserted values : none, html, xml, json ( inside a double-quoted string ), yaml ( inside a double-quoted scalar ), shell ( as a single word ), sql ( inside a single-quoted string ) or auto ( from the output file extension ). A value is inserted unescaped with the raw filter {{ value | raw }} ( default is 'none' )")
+	renderCmd.Flags().StringP("structured-output", "", rendering.NoStructured, "Serialization of the inserted values as valid fragments of the output : none, json, yaml or auto ( from the output file extension ). Strings are quoted and objects and lists serialized when a placeholder is a whole value, such as \"count\": {{n}} ( default is 'none' )")
+	renderCmd.Flags().StringP("partials", "", "", "Comma separated directories of the templates included with {{> name }}, searched after the input directory and not rendered as files")
+	renderCmd.Flags().StringP("loop-order", "", rendering.SortedKeyOrder, "Order of the entries of the maps iterated by the loop blocks, exposed as {{@key}} and {{.}} : sorted ( by key ) or insertion ( as in the file, for a json data file without streaming ) ( default is 'sorted' )")
+	renderCmd.Flags().StringP("stream", "", "false", "Whether to read the records of a csv data file or of a json array data file as they are rendered under the root loop variable, and write the output as it is rendered, keeping the memory use bounded. Requires a single output and no data filter ( default is 'false' )")
 	renderCmd.Flags().StringP("key-column", "k", "id", "Key column ( for .csv variable file ) ( default is 'id' }} )")
 	renderCmd.Flags().StringP("injection-loop-variable", "w", "$", "Name of the root loop variable in single file template ( default is '$' }} )")
 	renderCmd.Flags().StringP("multiple-output", "", "false", "Whether to generate multiple files from input template and an input data array ( default is 'false' }} )")
-	renderCmd.Flags().StringP("multiple-output-filename-pattern", "", "{0}_{i}", "Naming pattern of the generated files in case of multiple-output set to true. Example {0}_{i}_{variable_name} ( default is {0}_{i} }} with {0} : the current file name, {i} : the current file index and {variable_name} : a variable from the data)")
+	renderCmd.Flags().StringP("multiple-output-filename-pattern", "", "{0}_{i}", "Naming pattern of the generated files in case of multiple-output set to true. Example {0}_{i}_{variable_name} ( default is {0}_{i} }} with {0} : the current file name, {i} : the current file index and {variable_name} : a variable from the data, formatted with filters such as {day | date: \"%Y%m%d\"} or {total | number: 2})")
 	renderCmd.MarkFlagRequired("in")
 	renderCmd.MarkFlagRequired("out")
 	renderCmd.MarkFlagRequired("data")
@@ -89,6 +89,40 @@ func (suite *RenderTestSuite) Test_Render_CsvData_FileInput_SingleOutput() {
 	assert.Equal(suite.T(), reflect.DeepEqual(want, have), true)
 }
 
+func (suite *RenderTestSuite) Test_Render_CsvData_FileInput_StreamOutput() {
+	suite.cmd.SetArgs([]string{
+		"render",
+		"--data",
+		suite.dataCsvFile,
+		"--in",
+		suite.inFile,
+		"--out",
+		suite.haveFile,
+		"--multiple-output",
+		"false",
+		"--data-filter",
+		"",
+		"--stream",
+		"true",
+	})
+
+	suite.cmd.Execute()
+	// the flags keep their value across the tests
+	renderCmd.Flags().Set("stream", "false")
+
+	want, err := os.ReadFile(suite.wantFile)
+	if err != nil {
+		log.Fatalf("unable to read want file: %v", err)
+	}
+
+	have, err := os.ReadFile(suite.haveFile)
+	if err != nil {
+		log.Fatalf("unable to read have file: %v", err)
+	}
+
+	assert.Equal(suite.T(), reflect.DeepEqual(want, have), true)
+}
+
 func (suite *RenderTestSuite) Test_Render_CsvData_FileInput_MultipleOutput() {
 	suite.cmd.SetArgs([]string{
 		"render",
@@ -547,6 +581,40 @@ func (suite *RenderTestSuite) Test_Render_JsonArrayData_FileInput_SingleOutput()
 	assert.Equal(suite.T(), reflect.DeepEqual(want, have), true)
 }
 
+func (suite *RenderTestSuite) Test_Render_JsonArrayData_FileInput_StreamOutput() {
+	suite.cmd.SetArgs([]string{
+		"render",
+		"--data",
+		suite.dataJsonFile,
+		"--in",
+		suite.inFile,
+		"--out",
+		suite.haveFile,
+		"--multiple-output",
+		"false",
+		"--data-filter",
+		"",
+		"--stream",
+		"true",
+	})
+
+	suite.cmd.Execute()
+	// the flags keep their value across the tests
+	renderCmd.Flags().Set("stream", "false")
+
+	want, err := os.ReadFile(suite.wantFile)
+	if err != nil {
+		log.Fatalf("unable to read want file: %v", err)
+	}
+
+	have, err := os.ReadFile(suite.haveFile)
+	if err != nil {
+		log.Fatalf("unable to read have file: %v", err)
+	}
+
+	assert.Equal(suite.T(), reflect.DeepEqual(want, have), true)
+}
+
 func (suite *RenderTestSuite) Test_Render_JsonArrayData_FileInput_MultipleOutput() {
 	suite.cmd.SetArgs([]string{
 		"render",
@@ -49,4 +49,8 @@ func init() {
 	serveCmd.MarkFlagRequired("port")
 	serveCmd.Flags().StringP("leftDelimiter", "l", "{{", "Left variable delimiter ( default is {{ )")
 	serveCmd.Flags().StringP("rightDelimiter", "r", "}}", "Right variable delimiter ( default is }} )")
+	serveCmd.Flags().StringP("leftLoopVariableDelimiter", "", "(", "Left loop variable and condition delimiter ( default is '(' )")
+	serveCmd.Flags().StringP("rightLoopVariableDelimiter", "", ")", "Right loop variable and condition delimiter ( default is ')' )")
+	serveCmd.Flags().StringP("leftLoopBlockDelimiter", "", "[", "Left loop and condition block delimiter ( default is '[' )")
+	serveCmd.Flags().StringP("rightLoopBlockDelimiter", "", "]", "Right loop and condition block delimiter ( default is ']' )")
 }
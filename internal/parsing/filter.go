@@ -14,19 +14,44 @@ func filterVariables(input interface{}, jsonPathFilter string) (output interface
 		filtered = filtering.Filter(input, jsonPathFilter)
 	}
 
+	// the decoded maps are kept as they are, an insertion order identifying them
 	if utils.IsArray(filtered) {
-		var arrayOutput []map[string]interface{}
-		utils.MarshalUnmarshal(filtered, &arrayOutput)
+		arrayOutput, ok := objectsOf(filtered)
+		if !ok {
+			utils.MarshalUnmarshal(filtered, &arrayOutput)
+		}
 		output = arrayOutput
 	} else {
-		var mapOutput map[string]interface{}
-		utils.MarshalUnmarshal(filtered, &mapOutput)
+		mapOutput, ok := filtered.(map[string]interface{})
+		if !ok {
+			utils.MarshalUnmarshal(filtered, &mapOutput)
+		}
 		output = mapOutput
 	}
 
 	return
 }
 
+// Objects of a decoded list only made of objects
+func objectsOf(input interface{}) ([]map[string]interface{}, bool) {
+	switch elements := input.(type) {
+	case []map[string]interface{}:
+		return elements, true
+	case []interface{}:
+		objects := make([]map[string]interface{}, 0, len(elements))
+		for _, element := range elements {
+			object, ok := element.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			objects = append(objects, object)
+		}
+		return objects, true
+	}
+
+	return nil, false
+}
+
 func filterAndRootVariables(iVariables interface{}, jsonPathFilter string, isMultipleOutput bool, loopInjectionVariable string) (variables []map[string]interface{}, err error) {
 	fVariables, err := filterVariables(iVariables, jsonPathFilter)
 	if err != nil {
@@ -1,7 +1,12 @@
 package parsing
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/sebps/template-engine/internal/rendering"
 )
 
 func ParseJSON(variablesBytes []byte) (variables interface{}, err error) {
@@ -12,3 +17,65 @@ func ParseJSON(variablesBytes []byte) (variables interface{}, err error) {
 
 	return
 }
+
+// Parse a JSON document as ParseJSON, along with the order of the keys of each of its objects as found in the document
+func ParseJSONOrdered(variablesBytes []byte) (variables interface{}, order *rendering.InsertionOrder, err error) {
+	decoder := json.NewDecoder(bytes.NewReader(variablesBytes))
+	order = rendering.NewInsertionOrder()
+
+	variables, err = decodeOrdered(decoder, order)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, nil, errors.New("invalid data after the top-level value")
+	}
+
+	return variables, order, nil
+}
+
+// Decode the next value of a JSON document, adding the keys of its objects to the order
+func decodeOrdered(decoder *json.Decoder, order *rendering.InsertionOrder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch token {
+	case json.Delim('['):
+		elements := []interface{}{}
+		for decoder.More() {
+			element, err := decodeOrdered(decoder, order)
+			if err != nil {
+				return nil, err
+			}
+			elements = append(elements, element)
+		}
+		_, err = decoder.Token()
+		return elements, err
+	case json.Delim('{'):
+		object := make(map[string]interface{})
+		var keys []string
+		for decoder.More() {
+			token, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			key := token.(string)
+			value, err := decodeOrdered(decoder, order)
+			if err != nil {
+				return nil, err
+			}
+			// a duplicated key keeps its first position and its last value, as with ParseJSON
+			if _, ok := object[key]; !ok {
+				keys = append(keys, key)
+			}
+			object[key] = value
+		}
+		order.Add(object, keys)
+		_, err = decoder.Token()
+		return object, err
+	}
+
+	return token, nil
+}
@@ -0,0 +1,105 @@
+package parsing
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/sebps/template-engine/internal/rendering"
+)
+
+func TestParseJSONOrdered(t *testing.T) {
+	tests := []struct {
+		data string
+		// keys of the objects found at the paths, in iteration order
+		keys map[string][]string
+	}{
+		{
+			data: `{"b": 1, "a": {"z": true, "y": null}, "c": [{"q": 1, "p": 2}, {"p": 1, "q": 2}]}`,
+			keys: map[string][]string{
+				"":    {"b", "a", "c"},
+				"a":   {"z", "y"},
+				"c.0": {"q", "p"},
+				"c.1": {"p", "q"},
+			},
+		},
+		{
+			data: `[{"b": "x", "a": "y"}, {}]`,
+			keys: map[string][]string{
+				"0": {"b", "a"},
+				"1": {},
+			},
+		},
+		{
+			data: `{"b": 1, "a": 2, "b": 3}`,
+			keys: map[string][]string{
+				"": {"b", "a"},
+			},
+		},
+	}
+
+	for i, tc := range tests {
+		have, order, err := ParseJSONOrdered([]byte(tc.data))
+		if err != nil {
+			t.Fatalf("test #%d unexpected error : %v", i+1, err)
+		}
+
+		want, _ := ParseJSON([]byte(tc.data))
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("test #%d expected the value of ParseJSON \n want : %v \n have : %v", i+1, want, have)
+		}
+
+		for path, keys := range tc.keys {
+			object := have
+			if path != "" {
+				for _, part := range strings.Split(path, ".") {
+					switch v := object.(type) {
+					case map[string]interface{}:
+						object = v[part]
+					case []interface{}:
+						index, _ := strconv.Atoi(part)
+						object = v[index]
+					}
+				}
+			}
+			if ordered := order.Keys(object.(map[string]interface{})); !reflect.DeepEqual(ordered, keys) {
+				t.Errorf("test #%d keys of %q expected %v, have %v", i+1, path, keys, ordered)
+			}
+		}
+	}
+
+	for _, data := range []string{`{"a": 1`, `{"a": 1} {"b": 2}`, `[1,]`} {
+		if _, _, err := ParseJSONOrdered([]byte(data)); err == nil {
+			t.Errorf("expected an error parsing %s", data)
+		}
+	}
+}
+
+func TestParseJSONOrderedRendering(t *testing.T) {
+	data := []byte(`[{"name": "a", "env": {"PORT": "80", "HOST": "h"}}, {"name": "b", "env": {"HOST": "i", "PORT": "81"}}]`)
+	parsed, order, err := ParseJSONOrdered(data)
+	if err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+
+	// the decoded objects are kept as they are when rooted under the loop variable
+	variables, err := filterAndRootVariables(parsed, "", false, "$")
+	if err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+
+	compiled, err := rendering.Compile("($)(;)[{{name}}:(env)(,)[{{@key}}={{.}}]]", rendering.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+	var b strings.Builder
+	if _, err := compiled.ExecuteTo(&b, variables[0], order); err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+
+	want := "a:PORT=80,HOST=h;b:HOST=i,PORT=81"
+	if b.String() != want {
+		t.Errorf("failed expected result \n want : %s \n have : %s", want, b.String())
+	}
+}
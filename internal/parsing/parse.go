@@ -4,6 +4,8 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+
+	"github.com/sebps/template-engine/internal/rendering"
 )
 
 func ParseVariablesFile(path string, jsonPathFilter string, keyColumn string, isMultipleOutput bool, loopInjectionVariable string) (variables []map[string]interface{}, err error) {
@@ -45,6 +47,26 @@ func ParseVariablesFile(path string, jsonPathFilter string, keyColumn string, is
 	return
 }
 
+// Parse a JSON data file as ParseVariablesFile, along with the order of the keys of its objects
+func ParseOrderedVariablesFile(path string, jsonPathFilter string, isMultipleOutput bool, loopInjectionVariable string) (variables []map[string]interface{}, order *rendering.InsertionOrder, err error) {
+	variablesBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	iVariables, order, err := ParseJSONOrdered(variablesBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	variables, err = filterAndRootVariables(iVariables, jsonPathFilter, isMultipleOutput, loopInjectionVariable)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return variables, order, nil
+}
+
 func ParseVariablesBytes(variablesBytes []byte, jsonPathFilter string, keyColumn string, isMultipleOutput bool, loopInjectionVariable string) (variables []map[string]interface{}, err error) {
 	var iVariables interface{}
 
@@ -4,6 +4,16 @@ import "encoding/json"
 
 func RootVariables(input interface{}, root string) (output map[string]interface{}, err error) {
 	output = make(map[string]interface{})
+	if objects, ok := input.([]map[string]interface{}); ok {
+		// the objects are kept as they are, an insertion order identifying them
+		elements := make([]interface{}, 0, len(objects))
+		for _, object := range objects {
+			elements = append(elements, object)
+		}
+		output[root] = elements
+		return
+	}
+
 	bInput, err := json.Marshal(input)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,355 @@
+package parsing
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sebps/template-engine/internal/rendering"
+)
+
+// Byte order mark skipped at the beginning of the data files
+var utf8BOM = []byte("\ufeff")
+
+// Open the records of a data file as a loop variable read as it is rendered, the whole file never being held in memory
+func OpenRecords(path string, keyColumn string) (rendering.Iterable, error) {
+	switch filepath.Ext(path) {
+	case ".csv":
+		return OpenCSVRecords(path, keyColumn)
+	case ".json":
+		return OpenJSONRecords(path)
+	}
+
+	return nil, errors.New("streaming requires a csv or json data file")
+}
+
+// Reader of the fields of a CSV row one at a time, with the separator, the lazy quotes and the line breaks of ParseCSV : \r\n is read as \n
+type csvFields struct {
+	r *bufio.Reader
+	// bytes and line breaks read
+	n     int64
+	lines int
+	end   bool
+}
+
+func (f *csvFields) readByte() (byte, error) {
+	c, err := f.r.ReadByte()
+	if err == nil {
+		f.n++
+	}
+	if c == '\n' {
+		f.lines++
+	}
+
+	return c, err
+}
+
+// Whether a line break follows : \n, \r\n or a \r ending the file
+func (f *csvFields) lineBreakAhead() bool {
+	p, _ := f.r.Peek(2)
+	return len(p) > 0 && p[0] == '\n' || len(p) > 0 && p[0] == '\r' && (len(p) == 1 || p[1] == '\n')
+}
+
+// Next field of the row, not ok once the row is over
+func (f *csvFields) next() (field string, ok bool, err error) {
+	if f.end {
+		return "", false, nil
+	}
+
+	var b strings.Builder
+	quoted := false
+	if p, err := f.r.Peek(1); err == nil && p[0] == '"' {
+		f.readByte()
+		quoted = true
+	}
+
+	for {
+		if f.lineBreakAhead() {
+			if c, _ := f.readByte(); c == '\r' {
+				if _, err := f.readByte(); err == io.EOF {
+					// a carriage return ending the file is dropped
+					f.end = true
+					return b.String(), true, nil
+				}
+			}
+			if quoted {
+				b.WriteByte('\n')
+				continue
+			}
+			f.end = true
+			return b.String(), true, nil
+		}
+
+		c, err := f.readByte()
+		if err == io.EOF {
+			f.end = true
+			return b.String(), true, nil
+		}
+		if err != nil {
+			return "", false, err
+		}
+
+		switch {
+		case quoted && c == '"':
+			p, err := f.r.Peek(1)
+			if err == nil && p[0] == '"' {
+				// escaped quote
+				f.readByte()
+				b.WriteByte('"')
+			} else if err == nil && p[0] != ';' && !f.lineBreakAhead() {
+				// lazy quote inside a quoted field
+				b.WriteByte('"')
+			} else {
+				quoted = false
+			}
+		case !quoted && c == ';':
+			return b.String(), true, nil
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+// Skip the empty lines at the beginning of a row, returning false at the end of the file
+func (f *csvFields) skipEmptyLines() (bool, error) {
+	for {
+		p, err := f.r.Peek(1)
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		switch p[0] {
+		case '\n':
+			f.readByte()
+		case '\r':
+			if p, err := f.r.Peek(2); err == nil && p[1] == '\n' {
+				f.readByte()
+				f.readByte()
+				continue
+			}
+			return true, nil
+		default:
+			return true, nil
+		}
+	}
+}
+
+type csvRow struct {
+	offset int64
+	// variable of the row, found in the key column
+	key string
+}
+
+// Records of a CSV data file in the layout of ParseCSV : the rows are the variables, named by the key column, and the other columns are the records.
+// The rows are located once, then read side by side for each iteration, a single record being held in memory.
+type CSVRecords struct {
+	path      string
+	keyColumn int
+	columns   int
+	header    int64
+	rows      []csvRow
+}
+
+func OpenCSVRecords(path string, keyCol string) (*CSVRecords, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	fields := &csvFields{r: r}
+	if p, err := r.Peek(3); err == nil && bytes.Equal(p, utf8BOM) {
+		fields.readByte()
+		fields.readByte()
+		fields.readByte()
+	}
+
+	records := &CSVRecords{path: path, keyColumn: -1, header: -1}
+	for {
+		more, err := fields.skipEmptyLines()
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			break
+		}
+
+		offset, line := fields.n, fields.lines+1
+		fields.end = false
+		key := ""
+		columns := 0
+		for ; ; columns++ {
+			field, ok, err := fields.next()
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				break
+			}
+
+			if records.header == -1 && field == keyCol {
+				records.keyColumn = columns
+			} else if records.header != -1 && columns == records.keyColumn {
+				key = field
+			}
+		}
+
+		// the rows have as many fields as the header, as with ParseCSV
+		if records.header == -1 {
+			records.columns = columns
+		} else if columns != records.columns {
+			return nil, &csv.ParseError{StartLine: line, Line: line, Column: 1, Err: csv.ErrFieldCount}
+		}
+
+		if records.header == -1 {
+			if records.keyColumn == -1 {
+				return nil, errors.New("key column not found")
+			}
+			records.header = offset
+		} else if key != "" {
+			// a row without variable is not part of the records
+			records.rows = append(records.rows, csvRow{offset: offset, key: key})
+		}
+	}
+
+	if records.header == -1 {
+		return nil, errors.New("key column not found")
+	}
+
+	return records, nil
+}
+
+type csvIterator struct {
+	file      *os.File
+	keyColumn int
+	column    int
+	header    *csvFields
+	rows      []*csvFields
+	keys      []string
+}
+
+func (c *CSVRecords) Iterate() (rendering.Iterator, error) {
+	file, err := os.Open(c.path)
+	if err != nil {
+		return nil, err
+	}
+
+	// the rows share the file, each one reading its own section
+	fieldsAt := func(offset int64) *csvFields {
+		return &csvFields{r: bufio.NewReader(io.NewSectionReader(file, offset, math.MaxInt64-offset))}
+	}
+
+	it := &csvIterator{file: file, keyColumn: c.keyColumn, header: fieldsAt(c.header)}
+	for _, row := range c.rows {
+		it.rows = append(it.rows, fieldsAt(row.offset))
+		it.keys = append(it.keys, row.key)
+	}
+
+	return it, nil
+}
+
+func (it *csvIterator) Next() (interface{}, bool, error) {
+	for {
+		_, ok, err := it.header.next()
+		if err != nil || !ok {
+			return nil, false, err
+		}
+
+		column := it.column
+		it.column++
+
+		record := make(map[string]interface{}, len(it.rows))
+		for i, row := range it.rows {
+			value, ok, err := row.next()
+			if err != nil {
+				return nil, false, err
+			}
+			if ok && column != it.keyColumn {
+				record[it.keys[i]] = value
+			}
+		}
+
+		if column != it.keyColumn {
+			return record, true, nil
+		}
+	}
+}
+
+func (it *csvIterator) Close() error {
+	return it.file.Close()
+}
+
+// Elements of a JSON data file made of a top-level array, decoded one at a time for each iteration
+type JSONRecords struct {
+	path string
+}
+
+func OpenJSONRecords(path string) (*JSONRecords, error) {
+	records := &JSONRecords{path: path}
+
+	it, err := records.Iterate()
+	if err != nil {
+		return nil, err
+	}
+	it.Close()
+
+	return records, nil
+}
+
+type jsonIterator struct {
+	file    *os.File
+	decoder *json.Decoder
+}
+
+func (j *JSONRecords) Iterate() (rendering.Iterator, error) {
+	file, err := os.Open(j.path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(file)
+	if p, err := r.Peek(3); err == nil && bytes.Equal(p, utf8BOM) {
+		r.Discard(3)
+	}
+
+	decoder := json.NewDecoder(r)
+	token, err := decoder.Token()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		file.Close()
+		return nil, errors.New("streaming requires a json array of records")
+	}
+
+	return &jsonIterator{file: file, decoder: decoder}, nil
+}
+
+func (it *jsonIterator) Next() (interface{}, bool, error) {
+	if !it.decoder.More() {
+		return nil, false, nil
+	}
+
+	var element interface{}
+	if err := it.decoder.Decode(&element); err != nil {
+		return nil, false, err
+	}
+
+	return element, true, nil
+}
+
+func (it *jsonIterator) Close() error {
+	return it.file.Close()
+}
@@ -0,0 +1,106 @@
+package parsing
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sebps/template-engine/internal/rendering"
+)
+
+// Elements of a streamed data file, read in a single iteration
+func readRecords(iterable rendering.Iterable) ([]interface{}, error) {
+	it, err := iterable.Iterate()
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	elements := []interface{}{}
+	for {
+		element, ok, err := it.Next()
+		if err != nil || !ok {
+			return elements, err
+		}
+		elements = append(elements, element)
+	}
+}
+
+func writeData(t *testing.T, name string, data string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("unable to write data file : %v", err)
+	}
+
+	return path
+}
+
+func TestOpenCSVRecords(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		// whether ParseCSV rejects the data, and the streamed records with it
+		fails bool
+	}{
+		{name: "key column first", data: "id;a;b\nname;x;y\nage;1;2\n"},
+		{name: "key column in the middle", data: "a;id;b\r\nname;x;y\r\nage;1;2"},
+		{name: "byte order mark", data: string(utf8BOM) + "id;a\nname;x\n"},
+		{name: "empty lines", data: "\nid;a;b\n\n\r\nname;x;y\n\n"},
+		{name: "rows without variable", data: "id;a;b\n;x;y\nname;z;w\n"},
+		{name: "quoted fields", data: "id;a;b\nname;\"x;y\";\"say \"\"hi\"\"\"\nage;\"1\";2\n"},
+		{name: "line breaks in quoted fields", data: "id;a;b\nname;\"x\ny\";\"z\r\nw\"\r\nage;1;2\r\n"},
+		{name: "lazy quotes", data: "id;a;b\nname;x\"y;\"z\"w\"\nage;\"1\";2\n"},
+		{name: "carriage returns", data: "id;a;b\nname;x\ry;z\r"},
+		{name: "quoted field ending the file", data: "id;a\nname;\"x\"\r"},
+		{name: "header only", data: "id;a;b\n"},
+		{name: "short row", data: "id;a;b\nname;x\nage;1;2\n", fails: true},
+		{name: "long row", data: "id;a;b\nname;x;y\nage;1;2;3\n", fails: true},
+		{name: "lazy quote before a carriage return", data: "id;a;b\nname;x;y\nage;\"1\"\r;2\n", fails: true},
+		{name: "missing key column", data: "a;b\nname;x\n", fails: true},
+	}
+
+	for _, tc := range tests {
+		path := writeData(t, "data.csv", tc.data)
+
+		want, wantErr := ParseCSV([]byte(tc.data), "id")
+		records, err := OpenCSVRecords(path, "id")
+		if tc.fails {
+			if wantErr == nil || err == nil || err.Error() != wantErr.Error() {
+				t.Errorf("%s : expected the error of ParseCSV %v, have %v", tc.name, wantErr, err)
+			}
+			continue
+		}
+		if wantErr != nil || err != nil {
+			t.Fatalf("%s : unexpected errors %v %v", tc.name, wantErr, err)
+		}
+
+		// the records are read again for each iteration
+		for i := 0; i < 2; i++ {
+			have, err := readRecords(records)
+			if err != nil || !reflect.DeepEqual(have, want) {
+				t.Errorf("%s : expected the records of ParseCSV \n want : %q \n have : %q ( %v )", tc.name, want, have, err)
+			}
+		}
+	}
+}
+
+func TestOpenJSONRecords(t *testing.T) {
+	data := `[{"name": "x", "tags": ["a"]}, {"name": "y", "age": 2}, null]`
+	want, _ := ParseJSON([]byte(data))
+
+	records, err := OpenRecords(writeData(t, "data.json", string(utf8BOM)+data), "id")
+	if err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+	if have, err := readRecords(records); err != nil || !reflect.DeepEqual(have, want) {
+		t.Errorf("expected the records of ParseJSON \n want : %v \n have : %v ( %v )", want, have, err)
+	}
+
+	if _, err := OpenRecords(writeData(t, "data.json", `{"name": "x"}`), "id"); err == nil {
+		t.Errorf("expected an error streaming a json object")
+	}
+	if _, err := OpenRecords(writeData(t, "data.xlsx", ""), "id"); err == nil {
+		t.Errorf("expected an error streaming an xlsx data file")
+	}
+}
@@ -0,0 +1,206 @@
+package rendering
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Location of an error in a template : the name of the template, the line and column ( starting at 1 ) and the source line
+type Location struct {
+	Template string `json:"template,omitempty"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Excerpt  string `json:"excerpt"`
+	// byte offset in the template, or in the expression for the errors of an expression not located yet
+	offset int
+}
+
+func (l *Location) location() *Location {
+	return l
+}
+
+// Prefix a message with the location and follow it with the source excerpt pointing at the column
+func (l *Location) format(message string) string {
+	if l.Line == 0 {
+		return message
+	}
+
+	prefix := fmt.Sprintf("%d:%d", l.Line, l.Column)
+	if l.Template != "" {
+		prefix = l.Template + ":" + prefix
+	}
+
+	gutter := fmt.Sprint(l.Line)
+	caret := strings.Repeat(" ", l.Column-1)
+
+	return fmt.Sprintf(
+		"%s: %s\n %s | %s\n %s | %s^",
+		prefix,
+		message,
+		gutter,
+		l.Excerpt,
+		strings.Repeat(" ", len(gutter)),
+		caret,
+	)
+}
+
+type locatedError interface {
+	error
+	location() *Location
+}
+
+// Move a located error by an offset : the errors of an expression are raised at their position in the expression, then moved to the position of the expression in the template
+func moveError(err error, offset int) error {
+	var located locatedError
+	if errors.As(err, &located) {
+		located.location().offset += offset
+	}
+
+	return err
+}
+
+// Fill the location of an error from its offset in the source of a template
+func locateError(err error, name string, source string) error {
+	var located locatedError
+	if !errors.As(err, &located) {
+		return err
+	}
+
+	l := located.location()
+	if l.Line != 0 {
+		// already located in an included template
+		return err
+	}
+
+	offset := l.offset
+	if offset > len(source) {
+		offset = len(source)
+	}
+
+	lineStart := strings.LastIndexByte(source[:offset], '\n') + 1
+	lineEnd := strings.IndexByte(source[offset:], '\n')
+	if lineEnd == -1 {
+		lineEnd = len(source)
+	} else {
+		lineEnd += offset
+	}
+
+	l.Template = name
+	l.Line = strings.Count(source[:offset], "\n") + 1
+	l.Column = utf8.RuneCountInString(source[lineStart:offset]) + 1
+	l.Excerpt = strings.TrimRight(source[lineStart:lineEnd], "\r")
+
+	return err
+}
+
+// Error raised when a required variable, or any variable when the PanicIfNoMatch option is set, is not found in the data
+type MissingVariableError struct {
+	Location
+	Variable string `json:"variable"`
+}
+
+func (e *MissingVariableError) Error() string {
+	return e.format(fmt.Sprintf("variable : %q not found in data", e.Variable))
+}
+
+// Error raised when the PanicIfNoMatch option is set and placeholders were left untouched, listing all of them
+type MissingVariablesError struct {
+	Missing []*MissingVariableError
+}
+
+func (e *MissingVariablesError) Error() string {
+	messages := make([]string, 0, len(e.Missing))
+	for _, missing := range e.Missing {
+		messages = append(messages, missing.Error())
+	}
+
+	return strings.Join(messages, "\n")
+}
+
+// Error raised when a loop or condition block is never closed
+type UnclosedBlockError struct {
+	Location
+	Block string
+}
+
+func (e *UnclosedBlockError) Error() string {
+	return e.format(fmt.Sprintf("block %q is not closed", e.Block))
+}
+
+// Error raised when a value does not have the type its use requires, such as a loop variable which is not a list
+type TypeMismatchError struct {
+	Location
+	Message string
+}
+
+func (e *TypeMismatchError) Error() string {
+	return e.format(e.Message)
+}
+
+// Error raised when a delimiter option cannot be used
+type InvalidDelimiterError struct {
+	Location
+	Option    string
+	Delimiter string
+	Reason    string
+}
+
+func (e *InvalidDelimiterError) Error() string {
+	return e.format(fmt.Sprintf("invalid %s %q : %s", e.Option, e.Delimiter, e.Reason))
+}
+
+// Error raised when an option other than a delimiter has an unknown value
+type InvalidOptionError struct {
+	Option string
+	Value  string
+	Reason string
+}
+
+func (e *InvalidOptionError) Error() string {
+	return fmt.Sprintf("invalid %s %q : %s", e.Option, e.Value, e.Reason)
+}
+
+// Error raised when an included or extended template cannot be loaded or includes itself
+type IncludeError struct {
+	Location
+	Include string
+	// whether the template is extended rather than included
+	Extends bool
+	Message string
+}
+
+func (e *IncludeError) Error() string {
+	verb := "include"
+	if e.Extends {
+		verb = "extend"
+	}
+
+	return e.format(fmt.Sprintf("cannot %s %q : %s", verb, e.Include, e.Message))
+}
+
+// Error raised when the structure of a template is malformed, such as an "else" branch without a preceding "if" branch
+type SyntaxError struct {
+	Location
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return e.format(e.Message)
+}
+
+// Error raised when the elements of a loop variable produced by an Iterable cannot be read, such as a malformed data file
+type IterationError struct {
+	Location
+	Variable string
+	Err      error
+}
+
+func (e *IterationError) Error() string {
+	return e.format(fmt.Sprintf("cannot iterate over loop variable %q : %v", e.Variable, e.Err))
+}
+
+func (e *IterationError) Unwrap() error {
+	return e.Err
+}
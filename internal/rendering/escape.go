@@ -0,0 +1,116 @@
+package rendering
+
+import (
+	"bytes"
+	"encoding/json"
+	"html"
+	"path/filepath"
+	"strings"
+)
+
+// Escaping modes of the values inserted in a template, for the syntax of its output
+const (
+	// escaping chosen from the extension of the output path, resolved with EscapingFromExtension by the callers knowing it
+	AutoEscaping = "auto"
+	NoEscaping   = "none"
+	// values inserted in HTML text or in a quoted attribute
+	HTMLEscaping = "html"
+	// values inserted in XML text or in a quoted attribute
+	XMLEscaping = "xml"
+	// values inserted in a double-quoted JSON string
+	JSONEscaping = "json"
+	// values inserted in a double-quoted YAML scalar
+	YAMLEscaping = "yaml"
+	// values inserted as a single shell word, quoted when needed
+	ShellEscaping = "shell"
+	// values inserted in a single-quoted SQL string
+	SQLEscaping = "sql"
+)
+
+// Name of the filter opting a value out of the escaping, as the last filter of a placeholder such as {{ body | raw }}
+const RawFilter = "raw"
+
+// Value of a placeholder inserted without escaping
+type rawValue struct {
+	value interface{}
+}
+
+func rawFilter(value interface{}, arguments []interface{}) (interface{}, error) {
+	if err := checkArguments(arguments, 0, 0); err != nil {
+		return nil, err
+	}
+
+	return rawValue{value: value}, nil
+}
+
+var escapers = map[string]func(string) string{
+	NoEscaping:    nil,
+	HTMLEscaping:  html.EscapeString,
+	XMLEscaping:   escapeXML,
+	JSONEscaping:  escapeJSON,
+	YAMLEscaping:  escapeJSON,
+	ShellEscaping: escapeShell,
+	SQLEscaping:   escapeSQL,
+}
+
+// Escaping modes by output file extension
+var extensionsEscaping = map[string]string{
+	".html": HTMLEscaping,
+	".htm":  HTMLEscaping,
+	".xml":  XMLEscaping,
+	".json": JSONEscaping,
+	".yaml": YAMLEscaping,
+	".yml":  YAMLEscaping,
+	".sh":   ShellEscaping,
+	".bash": ShellEscaping,
+	".sql":  SQLEscaping,
+}
+
+// Escaping mode matching the extension of an output path, no escaping for unknown extensions
+func EscapingFromExtension(path string) string {
+	if escaping, ok := extensionsEscaping[strings.ToLower(filepath.Ext(path))]; ok {
+		return escaping
+	}
+
+	return NoEscaping
+}
+
+var xmlReplacer = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+	`"`, "&quot;",
+	"'", "&apos;",
+)
+
+func escapeXML(s string) string {
+	return xmlReplacer.Replace(s)
+}
+
+// Escape a string as the content of a double-quoted JSON string, also valid in a double-quoted YAML scalar
+func escapeJSON(s string) string {
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s)
+
+	encoded := strings.TrimSuffix(b.String(), "\n")
+	return encoded[1 : len(encoded)-1]
+}
+
+func isShellSafe(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("@%+=:,./_-", r)
+}
+
+// Quote a string as a single shell word, unless it only contains safe characters
+func escapeShell(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool { return !isShellSafe(r) }) == -1 {
+		return s
+	}
+
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+func escapeSQL(s string) string {
+	return strings.ReplaceAll(s, "'", "''")
+}
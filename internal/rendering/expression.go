@@ -0,0 +1,908 @@
+package rendering
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type expressionTokenKind int
+
+const (
+	endToken expressionTokenKind = iota
+	pathToken
+	stringToken
+	numberToken
+	operatorToken
+)
+
+type expressionToken struct {
+	kind     expressionTokenKind
+	value    string
+	text     string
+	position int
+}
+
+// Error raised while parsing or evaluating an expression, the position being the offset of the faulty token in the expression
+type ExpressionError struct {
+	Location
+	Expression string
+	Position   int
+	Message    string
+}
+
+func newExpressionError(expression string, position int, message string) *ExpressionError {
+	return &ExpressionError{Location: Location{offset: position}, Expression: expression, Position: position, Message: message}
+}
+
+func (e *ExpressionError) Error() string {
+	return e.format(fmt.Sprintf("%s at position %d of expression %q", e.Message, e.Position, e.Expression))
+}
+
+// Operators sorted so that the longest ones are matched first
+var expressionOperators = []string{"==", "!=", "<=", ">=", "&&", "||", "??", "<", ">", "!", "(", ")", "|", ":", ",", "+", "-", "*", "/", "%"}
+
+// Marker ending a placeholder whose variables must be found in the data : {{ name! }}
+const RequiredMarker = "!"
+
+// Keywords of the expressions, the operator ones being normalized to their symbol
+var expressionKeywords = map[string]string{
+	"and": "&&",
+	"or":  "||",
+	"not": "!",
+}
+
+func isPathStart(c byte) bool {
+	return c == '_' || c == '$' || c == '@' || c == '.' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isPathPart(c byte) bool {
+	return isPathStart(c) || (c >= '0' && c <= '9')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLiteralKeyword(value string) bool {
+	switch value {
+	case "true", "false", "null", "nil":
+		return true
+	}
+
+	return false
+}
+
+// Split an expression into tokens
+func tokenizeExpression(expression string) ([]expressionToken, error) {
+	var tokens []expressionToken
+	cursor := 0
+
+	for cursor < len(expression) {
+		c := expression[cursor]
+		start := cursor
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			cursor++
+			continue
+		case c == '"' || c == '\'':
+			var value strings.Builder
+			cursor++
+			for cursor < len(expression) && expression[cursor] != c {
+				if expression[cursor] == '\\' && cursor+1 < len(expression) {
+					cursor++
+					switch expression[cursor] {
+					case 'n':
+						value.WriteByte('\n')
+					case 't':
+						value.WriteByte('\t')
+					default:
+						value.WriteByte(expression[cursor])
+					}
+				} else {
+					value.WriteByte(expression[cursor])
+				}
+				cursor++
+			}
+			if cursor >= len(expression) {
+				return nil, newExpressionError(expression, start, "unterminated string")
+			}
+			cursor++
+			tokens = append(tokens, expressionToken{kind: stringToken, value: value.String(), text: expression[start:cursor], position: start})
+			continue
+		case isDigit(c):
+			for cursor < len(expression) && (isDigit(expression[cursor]) || expression[cursor] == '.') {
+				cursor++
+			}
+			tokens = append(tokens, expressionToken{kind: numberToken, value: expression[start:cursor], text: expression[start:cursor], position: start})
+			continue
+		case isPathStart(c):
+			for cursor < len(expression) && (isPathPart(expression[cursor]) || expression[cursor] == '[') {
+				if expression[cursor] == '[' {
+					end := strings.IndexByte(expression[cursor:], ']')
+					if end == -1 {
+						return nil, newExpressionError(expression, cursor, "unterminated index")
+					}
+					cursor += end
+				}
+				cursor++
+			}
+
+			value := expression[start:cursor]
+			if operator, ok := expressionKeywords[value]; ok {
+				tokens = append(tokens, expressionToken{kind: operatorToken, value: operator, text: value, position: start})
+			} else {
+				tokens = append(tokens, expressionToken{kind: pathToken, value: value, text: value, position: start})
+			}
+			continue
+		}
+
+		matched := false
+		for _, operator := range expressionOperators {
+			if strings.HasPrefix(expression[cursor:], operator) {
+				tokens = append(tokens, expressionToken{kind: operatorToken, value: operator, text: operator, position: start})
+				cursor += len(operator)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil, newExpressionError(expression, start, fmt.Sprintf("unexpected character %q", c))
+		}
+	}
+
+	tokens = append(tokens, expressionToken{kind: endToken, position: len(expression)})
+
+	return tokens, nil
+}
+
+// Expression evaluated against the variables of a scope
+type Expression interface {
+	Evaluate(scope *Scope) (interface{}, error)
+}
+
+type literalExpression struct {
+	value interface{}
+}
+
+type pathExpression struct {
+	path string
+}
+
+type notExpression struct {
+	operand Expression
+}
+
+type negateExpression struct {
+	operand    Expression
+	expression string
+	position   int
+}
+
+type filterExpression struct {
+	input      Expression
+	name       string
+	arguments  []Expression
+	expression string
+	position   int
+}
+
+type binaryExpression struct {
+	operator   string
+	left       Expression
+	right      Expression
+	expression string
+	position   int
+}
+
+func (e *literalExpression) Evaluate(scope *Scope) (interface{}, error) {
+	return e.value, nil
+}
+
+func (e *pathExpression) Evaluate(scope *Scope) (interface{}, error) {
+	// a missing variable evaluates to null
+	value, _ := scope.Resolve(e.path)
+	return value, nil
+}
+
+func (e *notExpression) Evaluate(scope *Scope) (interface{}, error) {
+	value, err := e.operand.Evaluate(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	return !IsTruthy(value), nil
+}
+
+func (e *negateExpression) Evaluate(scope *Scope) (interface{}, error) {
+	value, err := e.operand.Evaluate(scope)
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	number, ok := toNumber(unwrapRaw(value))
+	if !ok {
+		return nil, &TypeMismatchError{
+			Location: Location{offset: e.position},
+			Message:  fmt.Sprintf("cannot negate %T at position %d of expression %q", value, e.position, e.expression),
+		}
+	}
+
+	return -number, nil
+}
+
+func (e *filterExpression) Evaluate(scope *Scope) (interface{}, error) {
+	filter, ok := Filters[e.name]
+	if !ok {
+		return nil, newExpressionError(e.expression, e.position, fmt.Sprintf("unknown filter %q", e.name))
+	}
+
+	input, err := e.input.Evaluate(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	// a missing value goes through the filters untouched, only the default filter replaces it
+	if input == nil && e.name != DefaultFilter {
+		return nil, nil
+	}
+
+	arguments := make([]interface{}, 0, len(e.arguments))
+	for _, argument := range e.arguments {
+		value, err := argument.Evaluate(scope)
+		if err != nil {
+			return nil, err
+		}
+		arguments = append(arguments, value)
+	}
+
+	// a value out of the escaping stays out of it through the following filters
+	raw, isRaw := input.(rawValue)
+	if isRaw {
+		input = raw.value
+	}
+
+	output, err := filter(input, arguments)
+	if err != nil {
+		return nil, newExpressionError(e.expression, e.position, fmt.Sprintf("filter %q : %v", e.name, err))
+	}
+
+	if _, ok := output.(rawValue); isRaw && !ok {
+		return rawValue{value: output}, nil
+	}
+	return output, nil
+}
+
+func (e *binaryExpression) Evaluate(scope *Scope) (interface{}, error) {
+	left, err := e.left.Evaluate(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	// logical operators short circuit, the fallback of a null value is only evaluated when needed
+	if e.operator == "??" && left != nil {
+		return left, nil
+	}
+	if e.operator == "&&" && !IsTruthy(left) {
+		return false, nil
+	}
+	if e.operator == "||" && IsTruthy(left) {
+		return true, nil
+	}
+
+	right, err := e.right.Evaluate(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.operator {
+	case "??":
+		return right, nil
+	case "&&", "||":
+		return IsTruthy(right), nil
+	}
+
+	left, right = unwrapRaw(left), unwrapRaw(right)
+	switch e.operator {
+	case "==":
+		return equalValues(left, right), nil
+	case "!=":
+		return !equalValues(left, right), nil
+	case "+", "-", "*", "/", "%":
+		return e.arithmetic(left, right)
+	}
+
+	comparison, ok := compareValues(left, right)
+	if !ok {
+		return nil, &TypeMismatchError{
+			Location: Location{offset: e.position},
+			Message:  fmt.Sprintf("cannot compare %T and %T with %q at position %d of expression %q", left, right, e.operator, e.position, e.expression),
+		}
+	}
+
+	switch e.operator {
+	case "<":
+		return comparison < 0, nil
+	case "<=":
+		return comparison <= 0, nil
+	case ">":
+		return comparison > 0, nil
+	default:
+		return comparison >= 0, nil
+	}
+}
+
+// Result of an arithmetic operator, null when one of its operands is null.
+// The + operator concatenates the operands when one of them is a string, unless the other one is a number and the string converts to a number ( csv and xlsx data are strings ).
+func (e *binaryExpression) arithmetic(left interface{}, right interface{}) (interface{}, error) {
+	if left == nil || right == nil {
+		return nil, nil
+	}
+
+	l, lok := toNumber(left)
+	r, rok := toNumber(right)
+	numbers := lok && rok && (isNumber(left) || isNumber(right))
+
+	if e.operator == "+" && !numbers {
+		_, lstring := left.(string)
+		_, rstring := right.(string)
+		if lstring || rstring {
+			return toString(left) + toString(right), nil
+		}
+	}
+
+	if !lok || !rok {
+		return nil, &TypeMismatchError{
+			Location: Location{offset: e.position},
+			Message:  fmt.Sprintf("cannot apply %q to %T and %T at position %d of expression %q", e.operator, left, right, e.position, e.expression),
+		}
+	}
+
+	switch e.operator {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	}
+
+	if r == 0 {
+		return nil, newExpressionError(e.expression, e.position, "division by zero")
+	}
+	if e.operator == "/" {
+		return l / r, nil
+	}
+	return math.Mod(l, r), nil
+}
+
+type expressionParser struct {
+	expression string
+	tokens     []expressionToken
+	cursor     int
+}
+
+func (p *expressionParser) peek() expressionToken {
+	return p.tokens[p.cursor]
+}
+
+func (p *expressionParser) next() expressionToken {
+	token := p.tokens[p.cursor]
+	if token.kind != endToken {
+		p.cursor++
+	}
+	return token
+}
+
+func (p *expressionParser) isOperator(operators ...string) bool {
+	token := p.peek()
+	if token.kind != operatorToken {
+		return false
+	}
+	for _, operator := range operators {
+		if token.value == operator {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *expressionParser) errorf(token expressionToken, format string, args ...interface{}) error {
+	return newExpressionError(p.expression, token.position, fmt.Sprintf(format, args...))
+}
+
+// pipeline := fallback ( "|" name ( ":" fallback ( "," fallback )* )? )*
+func (p *expressionParser) parsePipeline() (Expression, error) {
+	input, err := p.parseFallback()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isOperator("|") {
+		p.next()
+
+		token := p.next()
+		if token.kind != pathToken {
+			return nil, p.errorf(token, "missing filter name")
+		}
+
+		filter := &filterExpression{input: input, name: token.value, expression: p.expression, position: token.position}
+		if p.isOperator(":") {
+			p.next()
+			for {
+				argument, err := p.parseFallback()
+				if err != nil {
+					return nil, err
+				}
+				filter.arguments = append(filter.arguments, argument)
+
+				if !p.isOperator(",") {
+					break
+				}
+				p.next()
+			}
+		}
+
+		input = filter
+	}
+
+	return input, nil
+}
+
+// fallback := or ( "??" or )*
+func (p *expressionParser) parseFallback() (Expression, error) {
+	left, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isOperator("??") {
+		token := p.next()
+		right, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpression{operator: "??", left: left, right: right, expression: p.expression, position: token.position}
+	}
+
+	return left, nil
+}
+
+// or := and ( "||" and )*
+func (p *expressionParser) parseOr() (Expression, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isOperator("||") {
+		token := p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpression{operator: "||", left: left, right: right, expression: p.expression, position: token.position}
+	}
+
+	return left, nil
+}
+
+// and := not ( "&&" not )*
+func (p *expressionParser) parseAnd() (Expression, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isOperator("&&") {
+		token := p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpression{operator: "&&", left: left, right: right, expression: p.expression, position: token.position}
+	}
+
+	return left, nil
+}
+
+// not := "!" not | comparison
+func (p *expressionParser) parseNot() (Expression, error) {
+	if p.isOperator("!") {
+		p.next()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpression{operand: operand}, nil
+	}
+
+	return p.parseComparison()
+}
+
+// comparison := additive ( ( "==" | "!=" | "<" | "<=" | ">" | ">=" ) additive )?
+func (p *expressionParser) parseComparison() (Expression, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.isOperator("==", "!=", "<", "<=", ">", ">=") {
+		token := p.next()
+		right, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpression{operator: token.value, left: left, right: right, expression: p.expression, position: token.position}
+	}
+
+	return left, nil
+}
+
+// additive := multiplicative ( ( "+" | "-" ) multiplicative )*
+func (p *expressionParser) parseAdditive() (Expression, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isOperator("+", "-") {
+		token := p.next()
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpression{operator: token.value, left: left, right: right, expression: p.expression, position: token.position}
+	}
+
+	return left, nil
+}
+
+// multiplicative := unary ( ( "*" | "/" | "%" ) unary )*
+func (p *expressionParser) parseMultiplicative() (Expression, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isOperator("*", "/", "%") {
+		token := p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpression{operator: token.value, left: left, right: right, expression: p.expression, position: token.position}
+	}
+
+	return left, nil
+}
+
+// unary := "-" unary | primary
+func (p *expressionParser) parseUnary() (Expression, error) {
+	if p.isOperator("-") {
+		token := p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &negateExpression{operand: operand, expression: p.expression, position: token.position}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+// primary := "(" or ")" | string | number | true | false | null | name "(" ( fallback ( "," fallback )* )? ")" | path
+func (p *expressionParser) parsePrimary() (Expression, error) {
+	token := p.next()
+
+	switch token.kind {
+	case stringToken:
+		return &literalExpression{value: token.value}, nil
+	case numberToken:
+		number, err := strconv.ParseFloat(token.value, 64)
+		if err != nil {
+			return nil, p.errorf(token, "invalid number %q", token.value)
+		}
+		return &literalExpression{value: number}, nil
+	case pathToken:
+		switch token.value {
+		case "true":
+			return &literalExpression{value: true}, nil
+		case "false":
+			return &literalExpression{value: false}, nil
+		case "null", "nil":
+			return &literalExpression{value: nil}, nil
+		}
+		if p.isOperator("(") {
+			return p.parseCall(token)
+		}
+		return &pathExpression{path: token.value}, nil
+	case operatorToken:
+		if token.value == "(" {
+			inner, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if !p.isOperator(")") {
+				return nil, p.errorf(p.peek(), "missing closing parenthesis")
+			}
+			p.next()
+			return inner, nil
+		}
+		return nil, p.errorf(token, "unexpected operator %q", token.value)
+	default:
+		return nil, p.errorf(token, "unexpected end of expression")
+	}
+}
+
+// call := name "(" ( fallback ( "," fallback )* )? ")"
+func (p *expressionParser) parseCall(name expressionToken) (Expression, error) {
+	p.next()
+	call := &callExpression{name: name.value, expression: p.expression, position: name.position}
+
+	for !p.isOperator(")") {
+		if len(call.arguments) > 0 {
+			if !p.isOperator(",") {
+				return nil, p.errorf(p.peek(), "missing closing parenthesis")
+			}
+			p.next()
+		}
+
+		argument, err := p.parseFallback()
+		if err != nil {
+			return nil, err
+		}
+		call.arguments = append(call.arguments, argument)
+	}
+	p.next()
+
+	return call, nil
+}
+
+// Parse an expression made of variable paths, literals, arithmetic and concatenation, comparisons, logical operators, fallbacks, filters and macro calls
+func ParseExpression(expression string) (Expression, error) {
+	tokens, err := tokenizeExpression(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &expressionParser{expression: expression, tokens: tokens}
+	parsed, err := p.parsePipeline()
+	if err != nil {
+		return nil, err
+	}
+
+	if token := p.peek(); token.kind != endToken {
+		return nil, p.errorf(token, "unexpected %q", token.text)
+	}
+
+	return parsed, nil
+}
+
+// Parse and evaluate an expression against a map of variables
+func EvaluateExpression(expression string, variables map[string]interface{}) (interface{}, error) {
+	parsed, err := ParseExpression(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsed.Evaluate(NewScope(variables, nil))
+}
+
+// Paths of the variables read by an expression
+func expressionPaths(expression Expression) []string {
+	switch e := expression.(type) {
+	case *pathExpression:
+		return []string{e.path}
+	case *notExpression:
+		return expressionPaths(e.operand)
+	case *negateExpression:
+		return expressionPaths(e.operand)
+	case *binaryExpression:
+		return append(expressionPaths(e.left), expressionPaths(e.right)...)
+	case *filterExpression:
+		paths := expressionPaths(e.input)
+		for _, argument := range e.arguments {
+			paths = append(paths, expressionPaths(argument)...)
+		}
+		return paths
+	case *callExpression:
+		// an unknown macro is missing like a variable
+		paths := []string{e.name}
+		for _, argument := range e.arguments {
+			paths = append(paths, expressionPaths(argument)...)
+		}
+		return paths
+	}
+
+	return nil
+}
+
+// Split the required marker from the content of a variable placeholder
+func splitRequiredMarker(content string) (string, bool) {
+	trimmed := strings.TrimSpace(content)
+	if !strings.HasSuffix(trimmed, RequiredMarker) {
+		return trimmed, false
+	}
+
+	return strings.TrimSpace(strings.TrimSuffix(trimmed, RequiredMarker)), true
+}
+
+// Content of a variable placeholder : a plain variable path or an expression followed by a fallback ( name ?? "default" ), filters and the required marker
+type placeholder struct {
+	content    string
+	path       string
+	required   bool
+	expression Expression
+	err        error
+}
+
+func compilePlaceholder(content string) *placeholder {
+	p := &placeholder{content: strings.TrimSpace(content)}
+	p.path, p.required = splitRequiredMarker(content)
+	p.expression, p.err = ParseExpression(p.path)
+
+	return p
+}
+
+// Evaluate a placeholder against the variables of a scope.
+// The placeholder is not found when its value is null because of a missing variable, or when its content is not an expression.
+// A required placeholder not found is an error, the content of a required placeholder being an expression.
+func (p *placeholder) evaluate(scope *Scope) (value interface{}, found bool, err error) {
+	if value, found = scope.Resolve(p.content); found {
+		return
+	}
+
+	if p.required {
+		if value, found = scope.Resolve(p.path); found {
+			return
+		}
+	}
+
+	if p.err != nil {
+		return nil, false, nil
+	}
+
+	value, err = p.expression.Evaluate(scope)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if value == nil {
+		for _, path := range expressionPaths(p.expression) {
+			if _, found := scope.Resolve(path); !found {
+				if p.required {
+					return nil, false, &MissingVariableError{Variable: path}
+				}
+				return nil, false, nil
+			}
+		}
+	}
+
+	return value, true, nil
+}
+
+// Evaluate the content of a variable placeholder against a map of variables
+func EvaluatePlaceholder(content string, variables map[string]interface{}) (value interface{}, found bool, err error) {
+	p := compilePlaceholder(content)
+	if p.required && p.err != nil {
+		return nil, false, p.err
+	}
+
+	return p.evaluate(NewScope(variables, nil))
+}
+
+// Whether a value is considered true by a condition : null, false, zero, empty strings and empty collections are not
+func IsTruthy(value interface{}) bool {
+	value = unwrapRaw(value)
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case float64:
+		return v != 0
+	case int:
+		return v != 0
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return rv.Len() > 0
+	}
+
+	return true
+}
+
+// Value of a raw value, the escaping not applying to the operands of an operator
+func unwrapRaw(value interface{}) interface{} {
+	if raw, ok := value.(rawValue); ok {
+		return raw.value
+	}
+
+	return value
+}
+
+// Decimal numbers written in a string, such as -1.5 or 2e3, unlike the 1., NaN, Inf or 0x1p3 spellings also read by strconv.ParseFloat
+var numberPattern = regexp.MustCompile(`^[+-]?(\d+(\.\d+)?|\.\d+)([eE][+-]?\d+)?$`)
+
+func toNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case string:
+		v = strings.TrimSpace(v)
+		if !numberPattern.MatchString(v) {
+			return 0, false
+		}
+		number, err := strconv.ParseFloat(v, 64)
+		return number, err == nil
+	}
+
+	return 0, false
+}
+
+func isNumber(value interface{}) bool {
+	switch value.(type) {
+	case float64, int:
+		return true
+	}
+
+	return false
+}
+
+// Compare two values as numbers when one of them is a number and the other one converts to a number ( csv and xlsx data are strings )
+func compareNumbers(left interface{}, right interface{}) (comparison int, ok bool) {
+	if !isNumber(left) && !isNumber(right) {
+		return 0, false
+	}
+
+	l, lok := toNumber(left)
+	r, rok := toNumber(right)
+	if !lok || !rok {
+		return 0, false
+	}
+
+	if l < r {
+		return -1, true
+	} else if l > r {
+		return 1, true
+	}
+
+	return 0, true
+}
+
+func equalValues(left interface{}, right interface{}) bool {
+	if left == nil || right == nil {
+		return left == nil && right == nil
+	}
+
+	if comparison, ok := compareNumbers(left, right); ok {
+		return comparison == 0
+	}
+
+	return fmt.Sprintf("%v", left) == fmt.Sprintf("%v", right)
+}
+
+func compareValues(left interface{}, right interface{}) (int, bool) {
+	if comparison, ok := compareNumbers(left, right); ok {
+		return comparison, true
+	}
+
+	l, lok := left.(string)
+	r, rok := right.(string)
+	if !lok || !rok {
+		return 0, false
+	}
+
+	return strings.Compare(l, r), true
+}
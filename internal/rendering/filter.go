@@ -0,0 +1,299 @@
+package rendering
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Name of the only filter receiving missing values
+const DefaultFilter = "default"
+
+// Max width of the pad filters, bounding the memory used by a template
+const MaxPadWidth = 1 << 16
+
+// Filter transforming a value in a placeholder pipeline such as {{ name | trim | truncate: 10, "..." | upper }}
+type Filter func(value interface{}, arguments []interface{}) (interface{}, error)
+
+// Built-in filters, by name
+var Filters = map[string]Filter{
+	"upper":       upperFilter,
+	"lower":       lowerFilter,
+	"title":       titleFilter,
+	"capitalize":  capitalizeFilter,
+	"trim":        trimFilter,
+	"replace":     replaceFilter,
+	"truncate":    truncateFilter,
+	"pad":         padFilter,
+	"padleft":     padLeftFilter,
+	DefaultFilter: defaultFilter,
+	"join":        joinFilter,
+	"length":      lengthFilter,
+	"json":        jsonFilter,
+	"yaml":        yamlFilter,
+	"number":      numberFilter,
+	"percent":     percentFilter,
+	"currency":    currencyFilter,
+	"date":        dateFilter,
+	RawFilter:     rawFilter,
+}
+
+func toString(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+
+	return formatValue(value)
+}
+
+func checkArguments(arguments []interface{}, min int, max int) error {
+	if len(arguments) < min || len(arguments) > max {
+		if min == max {
+			return fmt.Errorf("expects %d argument(s), %d given", min, len(arguments))
+		}
+		return fmt.Errorf("expects %d to %d argument(s), %d given", min, max, len(arguments))
+	}
+
+	return nil
+}
+
+func intArgument(arguments []interface{}, i int) (int, error) {
+	number, ok := toNumber(arguments[i])
+	if !ok || number != float64(int(number)) {
+		return 0, fmt.Errorf("argument %d must be an integer, %q given", i+1, toString(arguments[i]))
+	}
+
+	return int(number), nil
+}
+
+// Width or length argument of the string filters, which must not be negative nor exceed the max
+func widthArgument(arguments []interface{}, i int, max int) (int, error) {
+	width, err := intArgument(arguments, i)
+	if err == nil && width < 0 {
+		err = fmt.Errorf("argument %d must not be negative, %d given", i+1, width)
+	}
+	if err == nil && width > max {
+		err = fmt.Errorf("argument %d must not exceed %d, %d given", i+1, max, width)
+	}
+
+	return width, err
+}
+
+func stringArgument(arguments []interface{}, i int, fallback string) string {
+	if i >= len(arguments) {
+		return fallback
+	}
+
+	return toString(arguments[i])
+}
+
+func upperFilter(value interface{}, arguments []interface{}) (interface{}, error) {
+	if err := checkArguments(arguments, 0, 0); err != nil {
+		return nil, err
+	}
+
+	return strings.ToUpper(toString(value)), nil
+}
+
+func lowerFilter(value interface{}, arguments []interface{}) (interface{}, error) {
+	if err := checkArguments(arguments, 0, 0); err != nil {
+		return nil, err
+	}
+
+	return strings.ToLower(toString(value)), nil
+}
+
+// Upper case the first letter of each word
+func titleFilter(value interface{}, arguments []interface{}) (interface{}, error) {
+	if err := checkArguments(arguments, 0, 0); err != nil {
+		return nil, err
+	}
+
+	runes := []rune(toString(value))
+	for i, r := range runes {
+		if i == 0 || unicode.IsSpace(runes[i-1]) {
+			runes[i] = unicode.ToUpper(r)
+		}
+	}
+
+	return string(runes), nil
+}
+
+// Upper case the first letter of the value
+func capitalizeFilter(value interface{}, arguments []interface{}) (interface{}, error) {
+	if err := checkArguments(arguments, 0, 0); err != nil {
+		return nil, err
+	}
+
+	s := toString(value)
+	r, size := utf8.DecodeRuneInString(s)
+	if size == 0 {
+		return s, nil
+	}
+
+	return string(unicode.ToUpper(r)) + s[size:], nil
+}
+
+// Trim the white spaces, or the characters of the cutset given as argument, around the value
+func trimFilter(value interface{}, arguments []interface{}) (interface{}, error) {
+	if err := checkArguments(arguments, 0, 1); err != nil {
+		return nil, err
+	}
+
+	if len(arguments) == 1 {
+		return strings.Trim(toString(value), toString(arguments[0])), nil
+	}
+
+	return strings.TrimSpace(toString(value)), nil
+}
+
+func replaceFilter(value interface{}, arguments []interface{}) (interface{}, error) {
+	if err := checkArguments(arguments, 2, 2); err != nil {
+		return nil, err
+	}
+
+	return strings.ReplaceAll(toString(value), toString(arguments[0]), toString(arguments[1])), nil
+}
+
+// Cut the value to a number of characters, appending the optional suffix when it was cut
+func truncateFilter(value interface{}, arguments []interface{}) (interface{}, error) {
+	if err := checkArguments(arguments, 1, 2); err != nil {
+		return nil, err
+	}
+
+	length, err := widthArgument(arguments, 0, math.MaxInt)
+	if err != nil {
+		return nil, err
+	}
+
+	runes := []rune(toString(value))
+	if len(runes) <= length {
+		return string(runes), nil
+	}
+
+	return string(runes[:length]) + stringArgument(arguments, 1, ""), nil
+}
+
+func pad(value interface{}, arguments []interface{}, left bool) (interface{}, error) {
+	if err := checkArguments(arguments, 1, 2); err != nil {
+		return nil, err
+	}
+
+	width, err := widthArgument(arguments, 0, MaxPadWidth)
+	if err != nil {
+		return nil, err
+	}
+
+	padding := stringArgument(arguments, 1, " ")
+	if padding == "" {
+		return nil, fmt.Errorf("padding must not be empty")
+	}
+
+	s := toString(value)
+	missing := width - utf8.RuneCountInString(s)
+	if missing <= 0 {
+		return s, nil
+	}
+
+	fill := []rune(strings.Repeat(padding, missing))[:missing]
+	if left {
+		return string(fill) + s, nil
+	}
+
+	return s + string(fill), nil
+}
+
+// Pad the value on the right up to a width, with spaces or the padding given as argument
+func padFilter(value interface{}, arguments []interface{}) (interface{}, error) {
+	return pad(value, arguments, false)
+}
+
+// Pad the value on the left up to a width, with spaces or the padding given as argument
+func padLeftFilter(value interface{}, arguments []interface{}) (interface{}, error) {
+	return pad(value, arguments, true)
+}
+
+// Replace a missing, null or empty value with the argument
+func defaultFilter(value interface{}, arguments []interface{}) (interface{}, error) {
+	if err := checkArguments(arguments, 1, 1); err != nil {
+		return nil, err
+	}
+
+	if value == nil || value == "" {
+		return arguments[0], nil
+	}
+
+	return value, nil
+}
+
+// Join the elements of a list with a separator ( default is ", " )
+func joinFilter(value interface{}, arguments []interface{}) (interface{}, error) {
+	if err := checkArguments(arguments, 0, 1); err != nil {
+		return nil, err
+	}
+
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expects a list, %T given", value)
+	}
+
+	elements := make([]string, 0, len(list))
+	for _, e := range list {
+		elements = append(elements, toString(e))
+	}
+
+	return strings.Join(elements, stringArgument(arguments, 0, ", ")), nil
+}
+
+// Number of characters of a string or number of elements of a list or an object
+func lengthFilter(value interface{}, arguments []interface{}) (interface{}, error) {
+	if err := checkArguments(arguments, 0, 0); err != nil {
+		return nil, err
+	}
+
+	if s, ok := value.(string); ok {
+		return float64(utf8.RuneCountInString(s)), nil
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(rv.Len()), nil
+	}
+
+	return nil, fmt.Errorf("expects a string, a list or an object, %T given", value)
+}
+
+// Value serialized as JSON, a fragment of the output inserted without escaping
+func jsonFilter(value interface{}, arguments []interface{}) (interface{}, error) {
+	if err := checkArguments(arguments, 0, 0); err != nil {
+		return nil, err
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return rawValue{value: string(encoded)}, nil
+}
+
+// Value serialized as YAML, a fragment of the output inserted without escaping
+func yamlFilter(value interface{}, arguments []interface{}) (interface{}, error) {
+	if err := checkArguments(arguments, 0, 0); err != nil {
+		return nil, err
+	}
+
+	encoded, err := yaml.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return rawValue{value: strings.TrimRight(string(encoded), "\n")}, nil
+}
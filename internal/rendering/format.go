@@ -0,0 +1,315 @@
+package rendering
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Layouts of the dates parsed by the date filter when no input layout is given, tried in order
+var DateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"2006/01/02",
+	"02/01/2006 15:04:05",
+	"02/01/2006",
+	"02.01.2006",
+	"02 Jan 2006",
+	"2 January 2006",
+	"Jan 2, 2006",
+	"January 2, 2006",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+// Default layout of the date filter
+const DefaultDateLayout = "%Y-%m-%d"
+
+// Directives of the date layouts, such as %d/%m/%Y : their Go layout and the pattern of their text
+var dateDirectives = map[byte]struct {
+	layout  string
+	pattern string
+}{
+	'Y': {"2006", `\d{4}`},
+	'y': {"06", `\d{2}`},
+	'm': {"01", `\d{2}`},
+	'd': {"02", `\d{2}`},
+	'e': {"2", `\d{1,2}`},
+	'H': {"15", `\d{2}`},
+	'I': {"03", `\d{2}`},
+	'M': {"04", `\d{2}`},
+	'S': {"05", `\d{2}`},
+	'p': {"PM", `AM|PM`},
+	'b': {"Jan", `[A-Za-z]{3}`},
+	'B': {"January", `[A-Za-z]+`},
+	'a': {"Mon", `[A-Za-z]{3}`},
+	'A': {"Monday", `[A-Za-z]+`},
+	'z': {"-0700", `[+-]\d{4}|Z`},
+	'Z': {"MST", `[A-Z]{3,5}`},
+}
+
+// Format a value inserted in the output : numbers are written without exponent, such as 1000000 for 1e+06
+func formatValue(value interface{}) string {
+	if number, ok := value.(float64); ok && !math.IsInf(number, 0) && !math.IsNaN(number) {
+		return strconv.FormatFloat(number, 'f', -1, 64)
+	}
+
+	return fmt.Sprintf("%v", value)
+}
+
+// Format a number rounded half away from zero to a number of decimals, its integer part being grouped by thousands with a separator
+func formatNumber(number float64, decimals int, thousands string, decimal string) string {
+	scale := math.Pow10(decimals)
+	rounded := math.Abs(number)
+	if scaled := rounded * scale; !math.IsInf(scaled, 0) {
+		rounded = math.Round(scaled) / scale
+	}
+
+	formatted := strconv.FormatFloat(rounded, 'f', decimals, 64)
+	integer, fraction := formatted, ""
+	if dot := strings.IndexByte(formatted, '.'); dot != -1 {
+		integer, fraction = formatted[:dot], formatted[dot+1:]
+	}
+
+	var b strings.Builder
+	if number < 0 && strings.Trim(formatted, "0.") != "" {
+		b.WriteByte('-')
+	}
+	for i, digit := range integer {
+		if i > 0 && (len(integer)-i)%3 == 0 {
+			b.WriteString(thousands)
+		}
+		b.WriteRune(digit)
+	}
+	if fraction != "" {
+		b.WriteString(decimal)
+		b.WriteString(fraction)
+	}
+
+	return b.String()
+}
+
+func numberArgument(value interface{}) (float64, error) {
+	number, ok := toNumber(value)
+	if !ok {
+		return 0, fmt.Errorf("expects a number, %q given", toString(value))
+	}
+
+	return number, nil
+}
+
+// Number of decimals of the number filters, not negative
+func decimalsArgument(arguments []interface{}, i int, fallback int) (int, error) {
+	if i >= len(arguments) {
+		return fallback, nil
+	}
+
+	decimals, err := intArgument(arguments, i)
+	if err == nil && decimals < 0 {
+		err = fmt.Errorf("argument %d must not be negative, %d given", i+1, decimals)
+	}
+
+	return decimals, err
+}
+
+// Options of the number filters following their first arguments : the thousands separator ( none by default ) and the decimal separator ( "." by default )
+func separatorArguments(arguments []interface{}, i int, thousands string) (string, string) {
+	return stringArgument(arguments, i, thousands), stringArgument(arguments, i+1, ".")
+}
+
+// Number with a fixed number of decimals ( default is 0 ) and optional thousands and decimal separators : {{ total | number: 2, "," }}
+func numberFilter(value interface{}, arguments []interface{}) (interface{}, error) {
+	if err := checkArguments(arguments, 0, 3); err != nil {
+		return nil, err
+	}
+
+	number, err := numberArgument(value)
+	if err != nil {
+		return nil, err
+	}
+
+	decimals, err := decimalsArgument(arguments, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	thousands, decimal := separatorArguments(arguments, 1, "")
+
+	return formatNumber(number, decimals, thousands, decimal), nil
+}
+
+// Ratio as a percentage with a number of decimals ( default is 0 ) : {{ 0.256 | percent: 1 }} gives 25.6%
+func percentFilter(value interface{}, arguments []interface{}) (interface{}, error) {
+	if err := checkArguments(arguments, 0, 3); err != nil {
+		return nil, err
+	}
+
+	number, err := numberArgument(value)
+	if err != nil {
+		return nil, err
+	}
+
+	decimals, err := decimalsArgument(arguments, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	thousands, decimal := separatorArguments(arguments, 1, "")
+
+	return formatNumber(number*100, decimals, thousands, decimal) + "%", nil
+}
+
+// Amount with a currency symbol, 2 decimals and thousands grouped with "," by default : {{ price | currency: "$" }} gives $1,234.50.
+// A symbol starting with a white space follows the amount : {{ price | currency: " €", 2, " ", "," }} gives 1 234,50 €
+func currencyFilter(value interface{}, arguments []interface{}) (interface{}, error) {
+	if err := checkArguments(arguments, 1, 4); err != nil {
+		return nil, err
+	}
+
+	number, err := numberArgument(value)
+	if err != nil {
+		return nil, err
+	}
+
+	decimals, err := decimalsArgument(arguments, 1, 2)
+	if err != nil {
+		return nil, err
+	}
+	thousands, decimal := separatorArguments(arguments, 2, ",")
+
+	symbol := toString(arguments[0])
+	amount := formatNumber(number, decimals, thousands, decimal)
+	if strings.HasPrefix(symbol, " ") {
+		return amount + symbol, nil
+	}
+	if strings.HasPrefix(amount, "-") {
+		return "-" + symbol + amount[1:], nil
+	}
+	return symbol + amount, nil
+}
+
+// Part of a date layout : a literal text or the Go layout of a directive
+type datePart struct {
+	literal string
+	layout  string
+	pattern string
+}
+
+// Parts of a date layout made of directives such as %Y-%m-%d and of literal text, %% being a literal %
+func dateLayoutParts(layout string) ([]datePart, error) {
+	var parts []datePart
+	var literal strings.Builder
+	for i := 0; i < len(layout); i++ {
+		if layout[i] != '%' {
+			literal.WriteByte(layout[i])
+			continue
+		}
+
+		if i+1 >= len(layout) {
+			return nil, fmt.Errorf("unterminated directive in date layout %q", layout)
+		}
+		i++
+		if layout[i] == '%' {
+			literal.WriteByte('%')
+			continue
+		}
+		directive, ok := dateDirectives[layout[i]]
+		if !ok {
+			return nil, fmt.Errorf("unknown directive %%%c in date layout %q", layout[i], layout)
+		}
+		if literal.Len() > 0 {
+			parts = append(parts, datePart{literal: literal.String()})
+			literal.Reset()
+		}
+		parts = append(parts, datePart{layout: directive.layout, pattern: directive.pattern})
+	}
+	if literal.Len() > 0 {
+		parts = append(parts, datePart{literal: literal.String()})
+	}
+
+	return parts, nil
+}
+
+// Format a date with a layout of directives, its literal text being written as is
+func formatDate(date time.Time, layout string) (string, error) {
+	parts, err := dateLayoutParts(layout)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, part := range parts {
+		if part.layout == "" {
+			b.WriteString(part.literal)
+		} else {
+			b.WriteString(date.Format(part.layout))
+		}
+	}
+
+	return b.String(), nil
+}
+
+// Parse a date with a layout of directives, its literal text being matched as is
+func parseDateLayout(value string, layout string) (time.Time, error) {
+	parts, err := dateLayoutParts(layout)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// the text of each directive is captured, then parsed with the Go layouts of the directives
+	var pattern strings.Builder
+	var layouts []string
+	for _, part := range parts {
+		if part.layout == "" {
+			pattern.WriteString(regexp.QuoteMeta(part.literal))
+			continue
+		}
+		pattern.WriteString("(" + part.pattern + ")")
+		layouts = append(layouts, part.layout)
+	}
+
+	matches := regexp.MustCompile("^" + pattern.String() + "$").FindStringSubmatch(value)
+	if matches == nil {
+		return time.Time{}, fmt.Errorf("date %q does not match layout %q", value, layout)
+	}
+	date, err := time.Parse(strings.Join(layouts, " "), strings.Join(matches[1:], " "))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("date %q does not match layout %q", value, layout)
+	}
+
+	return date, nil
+}
+
+// Date parsed from one of the common layouts, or from the input layout given
+func parseDate(value string, layout string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if layout != "" {
+		return parseDateLayout(value, layout)
+	}
+
+	for _, layout := range DateLayouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("unknown date layout of %q", value)
+}
+
+// Date reformatted with a layout of directives ( default is %Y-%m-%d ), parsed from a common layout or from the input layout given : {{ birth | date: "%d/%m/%Y", "%m-%d-%y" }}
+func dateFilter(value interface{}, arguments []interface{}) (interface{}, error) {
+	if err := checkArguments(arguments, 0, 2); err != nil {
+		return nil, err
+	}
+
+	date, err := parseDate(toString(value), stringArgument(arguments, 1, ""))
+	if err != nil {
+		return nil, err
+	}
+
+	return formatDate(date, stringArgument(arguments, 0, DefaultDateLayout))
+}
@@ -0,0 +1,125 @@
+package rendering
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Marker of an include placeholder inserting another template, such as {{> partials/header.txt }}
+const IncludeMarker = ">"
+
+// Loader of the templates included by a template.
+// Being part of the options keying the compiled templates cache, its implementations should be comparable, such as pointers : the templates compiled with other ones are not cached.
+type Loader interface {
+	// Load a template by name, returning its source and the name identifying it in the errors and the include cycles
+	Load(name string) (source string, resolved string, err error)
+}
+
+// Loader of the templates found in a list of directories, searched in order
+type DirectoryLoader struct {
+	Directories []string
+}
+
+func NewDirectoryLoader(directories ...string) *DirectoryLoader {
+	return &DirectoryLoader{Directories: directories}
+}
+
+// Load a template from the first directory holding it, the names resolving outside of the directories such as ../secret.txt being rejected
+func (l *DirectoryLoader) Load(name string) (string, string, error) {
+	for _, directory := range l.Directories {
+		path := filepath.Join(directory, name)
+		if !isInDirectory(directory, path) {
+			return "", "", fmt.Errorf("template %q is outside of %s", name, directory)
+		}
+
+		content, err := os.ReadFile(path)
+		if err == nil {
+			return string(content), path, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", "", err
+		}
+	}
+
+	return "", "", fmt.Errorf("template not found in %s", strings.Join(l.Directories, ", "))
+}
+
+// Whether a path stays inside a directory once cleaned
+func isInDirectory(directory string, path string) bool {
+	relative, err := filepath.Rel(directory, path)
+	if err != nil {
+		return false
+	}
+
+	return relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
+
+// Name following the marker of an include or extends placeholder, optionally quoted
+func directiveName(content string, marker string) (name string, ok bool) {
+	trimmed := strings.TrimSpace(content)
+	if !strings.HasPrefix(trimmed, marker) {
+		return "", false
+	}
+
+	name = strings.TrimSpace(strings.TrimPrefix(trimmed, marker))
+	if len(name) >= 2 && (name[0] == '"' || name[0] == '\'') && name[len(name)-1] == name[0] {
+		name = name[1 : len(name)-1]
+	}
+
+	return name, true
+}
+
+// Included and extended templates resolution of a compilation : the loader and the templates being compiled, from the outermost one
+type includer struct {
+	loader Loader
+	d      *delimiters
+	stack  []string
+	// sources of the templates loaded by the compilation, by name
+	loaded map[string]string
+}
+
+// Load and parse the template included or extended at an offset of the template being compiled, returning its nodes, name and source
+func (inc *includer) load(name string, offset int, extends bool) ([]Node, string, string, error) {
+	if inc.loader == nil {
+		return nil, "", "", &IncludeError{Location: Location{offset: offset}, Include: name, Extends: extends, Message: "no loader set to include templates"}
+	}
+
+	source, resolved, err := inc.loader.Load(name)
+	if err != nil {
+		return nil, "", "", &IncludeError{Location: Location{offset: offset}, Include: name, Extends: extends, Message: err.Error()}
+	}
+	inc.loaded[name] = source
+
+	for _, including := range inc.stack {
+		if including == resolved {
+			cycle := strings.Join(append(append([]string{}, inc.stack...), resolved), " -> ")
+			return nil, "", "", &IncludeError{Location: Location{offset: offset}, Include: name, Extends: extends, Message: "include cycle " + cycle}
+		}
+	}
+
+	// the line break ending the loaded file is not part of the template
+	source = strings.TrimSuffix(strings.TrimSuffix(source, "\n"), "\r")
+
+	nested := &includer{loader: inc.loader, d: inc.d, stack: append(append([]string{}, inc.stack...), resolved), loaded: inc.loaded}
+	nodes, err := parseTokens(lex(source, inc.d, true), nested)
+	if err == nil {
+		nodes, err = nested.extend(nodes, resolved, source)
+	}
+	if err != nil {
+		return nil, "", "", locateError(err, resolved, source)
+	}
+
+	return nodes, resolved, source, nil
+}
+
+// Load and parse the template included at an offset of the template being compiled
+func (inc *includer) include(name string, offset int) (Node, error) {
+	nodes, resolved, source, err := inc.load(name, offset, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &templateNode{nodes: nodes, name: resolved, source: source}, nil
+}
@@ -0,0 +1,114 @@
+package rendering
+
+import "fmt"
+
+// Marker of an extends placeholder such as {{< layouts/base.html }} : the template is the extended one, whose named blocks are overridden by the ones of the extending template
+const ExtendsMarker = "<"
+
+// Name of the variable rendering the content of the overridden block inside an overriding block
+const SuperVariable = "@super"
+
+// Visit nodes and their children, with the name and source of the template they were parsed from.
+// The children of a node are not visited when visit returns false.
+func walkNodes(nodes []Node, name string, source string, visit func(node Node, name string, source string) bool) {
+	for _, node := range nodes {
+		if !visit(node, name, source) {
+			continue
+		}
+
+		switch n := node.(type) {
+		case *templateNode:
+			walkNodes(n.nodes, n.name, n.source, visit)
+		case *blockNode:
+			walkNodes(n.body, name, source, visit)
+		case *loopNode:
+			walkNodes(n.body, name, source, visit)
+			walkNodes(n.otherwise, name, source, visit)
+		case *conditionNode:
+			for _, branch := range n.branches {
+				walkNodes(branch.body, name, source, visit)
+			}
+		}
+	}
+}
+
+// Resolve the extends placeholder of a template : the nodes of the extended template, its named blocks being replaced with the ones of the template.
+// The content of the template outside of its named blocks is not rendered.
+func (inc *includer) extend(nodes []Node, name string, source string) ([]Node, error) {
+	var extends *extendsNode
+	for _, node := range nodes {
+		if e, ok := node.(*extendsNode); ok {
+			if extends != nil {
+				return nil, &SyntaxError{Location: Location{offset: e.position}, Message: "a template extends a single template"}
+			}
+			extends = e
+		}
+	}
+
+	var err error
+	blocks := make(map[string]*blockNode)
+	walkNodes(nodes, name, source, func(node Node, _ string, _ string) bool {
+		switch n := node.(type) {
+		case *templateNode:
+			// the blocks and extends placeholders of the included templates are their own
+			return false
+		case *blockNode:
+			if _, ok := blocks[n.name]; ok && err == nil {
+				err = &SyntaxError{Location: Location{offset: n.position}, Message: fmt.Sprintf("block %q is already defined", n.name)}
+			}
+			blocks[n.name] = n
+		case *extendsNode:
+			if n != extends && err == nil {
+				err = &SyntaxError{Location: Location{offset: n.position}, Message: "an extends placeholder cannot be nested in a block"}
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if extends == nil {
+		return nodes, nil
+	}
+
+	parentNodes, parentName, parentSource, err := inc.load(extends.name, extends.position, true)
+	if err != nil {
+		return nil, err
+	}
+
+	walkNodes(parentNodes, parentName, parentSource, func(node Node, blockName string, blockSource string) bool {
+		block, ok := node.(*blockNode)
+		if !ok {
+			return true
+		}
+		override, ok := blocks[block.name]
+		if !ok {
+			return true
+		}
+
+		previous := block.body
+		if !block.overridden {
+			previous = []Node{&templateNode{nodes: block.body, name: blockName, source: blockSource}}
+		}
+
+		// the super placeholders of the overriding block render the overridden content, the nested blocks having their own
+		walkNodes(override.body, name, source, func(child Node, _ string, _ string) bool {
+			switch c := child.(type) {
+			case *superNode:
+				c.parent = previous
+			case *blockNode, *templateNode:
+				return false
+			}
+			return true
+		})
+
+		block.body = []Node{&templateNode{nodes: override.body, name: name, source: source}}
+		block.overridden = true
+
+		// the overriding body is the one of the extending template, already resolved
+		return false
+	})
+
+	return []Node{&templateNode{nodes: parentNodes, name: parentName, source: parentSource}}, nil
+}
@@ -0,0 +1,15 @@
+package rendering
+
+// Loop variable producing its elements as they are rendered, such as the records of a large data file, instead of a list held in memory
+type Iterable interface {
+	// Start an iteration over the elements, each loop block over the variable starting its own
+	Iterate() (Iterator, error)
+}
+
+// Iteration over the elements of an Iterable
+type Iterator interface {
+	// Next element, not ok once all the elements were produced
+	Next() (element interface{}, ok bool, err error)
+	// Release the resources of the iteration, such as an open file
+	Close() error
+}
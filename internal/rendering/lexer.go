@@ -0,0 +1,524 @@
+package rendering
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Character escaping the delimiter of a tag, which is then plain content. Doubled, it is a literal escape character followed by a tag.
+const EscapeCharacter = "\\"
+
+// Marker removing the white spaces and line breaks next to a tag : {{- name }} before it, {{ name -}} after it, and ]- after a block footer.
+// In a placeholder it sticks to the delimiter and is separated from the content by a white space.
+const TrimMarker = "-"
+
+type tokenKind int
+
+const (
+	textToken tokenKind = iota
+	variableToken
+	headerToken
+	footerToken
+)
+
+// Token of a template, its text being the source it was read from
+type token struct {
+	kind     tokenKind
+	text     string
+	position int
+	// content of a variable placeholder or of a block header, and its offset in the source
+	value         string
+	valuePosition int
+	// joiner and indentation of a block header
+	joiner string
+	offset int
+	// whether a block header directly follows the footer of the previous branch of a condition
+	chained bool
+	// whether the white spaces preceding or following the tag are removed
+	trimLeft  bool
+	trimRight bool
+	// whether a loop block header or footer is inline, its block staying on a single line
+	inline bool
+}
+
+type delimiters struct {
+	left              string
+	right             string
+	leftLoopVariable  string
+	rightLoopVariable string
+	leftLoopBlock     string
+	rightLoopBlock    string
+}
+
+type lexer struct {
+	source    string
+	d         *delimiters
+	blocks    bool
+	tokens    []token
+	cursor    int
+	textStart int
+	// number of inline blocks opened and not closed yet
+	inlineDepth int
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func (l *lexer) skipSpaces(cursor int, lineBreaks bool) int {
+	for cursor < len(l.source) && (l.source[cursor] == ' ' || l.source[cursor] == '\t' || (lineBreaks && isSpace(l.source[cursor]))) {
+		cursor++
+	}
+	return cursor
+}
+
+// Read the content wrapped by the loop variable delimiters at cursor, on a single line.
+// Nested pairs of delimiters, such as the parentheses of a condition (if (a or b) and c), and the delimiters inside quoted strings are part of the content.
+func (l *lexer) wrapped(cursor int) (content string, start int, end int, ok bool) {
+	if !strings.HasPrefix(l.source[cursor:], l.d.leftLoopVariable) {
+		return "", cursor, cursor, false
+	}
+
+	start = cursor + len(l.d.leftLoopVariable)
+	line := len(l.source)
+	if lineEnd := strings.IndexByte(l.source[start:], '\n'); lineEnd != -1 {
+		line = start + lineEnd
+	}
+
+	// identical delimiters cannot be nested
+	nested := l.d.leftLoopVariable != l.d.rightLoopVariable
+	depth := 0
+	for end = start; end < line; {
+		switch c := l.source[end]; {
+		case c == '"' || c == '\'':
+			if closing := quotedEnd(l.source[:line], end); closing != -1 {
+				end = closing
+				continue
+			}
+		case strings.HasPrefix(l.source[end:], l.d.rightLoopVariable):
+			if depth == 0 {
+				return l.source[start:end], start, end + len(l.d.rightLoopVariable), true
+			}
+			depth--
+			end += len(l.d.rightLoopVariable)
+			continue
+		case nested && strings.HasPrefix(l.source[end:], l.d.leftLoopVariable):
+			depth++
+			end += len(l.d.leftLoopVariable)
+			continue
+		}
+		end++
+	}
+
+	return "", cursor, cursor, false
+}
+
+// End of the quoted string starting at cursor, after its closing quote, or -1 when it is not closed
+func quotedEnd(s string, cursor int) int {
+	for i := cursor + 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case s[cursor]:
+			return i + 1
+		}
+	}
+
+	return -1
+}
+
+func (l *lexer) lineBreak(cursor int) int {
+	if strings.HasPrefix(l.source[cursor:], "\n") {
+		return 1
+	}
+	if strings.HasPrefix(l.source[cursor:], "\r\n") {
+		return 2
+	}
+	return 0
+}
+
+// Match a block header at cursor : its indentation, its variable wrapped by the loop variable delimiters, an optional joiner and the opening block delimiter ending the line.
+// A chained header follows the footer of the previous branch, on the same line or on the next ones, and has no joiner.
+func (l *lexer) matchHeader(cursor int, chained bool) (token, bool) {
+	start := cursor
+	cursor = l.skipSpaces(cursor, chained)
+	offset := cursor - start
+
+	value, valuePosition, cursor, ok := l.wrapped(cursor)
+	if !ok {
+		return token{}, false
+	}
+
+	var joiner string
+	if !chained {
+		if j, _, end, ok := l.wrapped(cursor); ok {
+			joiner, cursor = unquoteJoiner(j), end
+		}
+	}
+
+	cursor = l.skipSpaces(cursor, true)
+	if !strings.HasPrefix(l.source[cursor:], l.d.leftLoopBlock) {
+		return token{}, false
+	}
+	cursor += len(l.d.leftLoopBlock)
+
+	lineBreak := l.lineBreak(cursor)
+	if lineBreak == 0 {
+		return token{}, false
+	}
+	cursor += lineBreak
+
+	return token{
+		kind:          headerToken,
+		text:          l.source[start:cursor],
+		position:      start,
+		value:         value,
+		valuePosition: valuePosition,
+		joiner:        joiner,
+		offset:        offset,
+		chained:       chained,
+	}, true
+}
+
+// Joiner of a loop, which can be quoted to hold white spaces : (", ")
+func unquoteJoiner(joiner string) string {
+	if len(joiner) >= 2 && joiner[0] == '"' && joiner[len(joiner)-1] == '"' {
+		if unquoted, err := strconv.Unquote(joiner); err == nil {
+			return unquoted
+		}
+	}
+
+	return joiner
+}
+
+func isInlineVariable(variable string) bool {
+	if variable == "" {
+		return false
+	}
+	for i := 0; i < len(variable); i++ {
+		if !isPathPart(variable[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Match the header of an inline loop block at cursor : its variable, an optional joiner and the opening block delimiter followed by the body on the same line.
+// A chained header is the else branch following the footer of an inline loop block. Another header does not follow an identifier, such as the call f(xs)[0].
+func (l *lexer) matchInlineHeader(cursor int, chained bool) (token, bool) {
+	start := cursor
+	if !chained && start > 0 && isPathPart(l.source[start-1]) {
+		return token{}, false
+	}
+
+	value, valuePosition, cursor, ok := l.wrapped(cursor)
+	if !ok || !isInlineVariable(value) {
+		return token{}, false
+	}
+	if keyword, _ := splitBlockHeader(value); keyword != "" && !(chained && keyword == ElseKeyword) {
+		return token{}, false
+	}
+
+	var joiner string
+	if j, _, end, ok := l.wrapped(cursor); ok {
+		joiner, cursor = unquoteJoiner(j), end
+	}
+
+	if !strings.HasPrefix(l.source[cursor:], l.d.leftLoopBlock) {
+		return token{}, false
+	}
+	cursor += len(l.d.leftLoopBlock)
+
+	return token{
+		kind:          headerToken,
+		text:          l.source[start:cursor],
+		position:      start,
+		value:         value,
+		valuePosition: valuePosition,
+		joiner:        joiner,
+		inline:        true,
+		chained:       chained,
+	}, true
+}
+
+// Whether the inline block whose body starts at cursor is closed on the same line
+func (l *lexer) closesInline(cursor int) bool {
+	depth := 1
+	for cursor < len(l.source) && l.source[cursor] != '\n' {
+		if _, length, ok := l.matchEscape(cursor, true); ok {
+			cursor += length
+			continue
+		}
+		if t, ok := l.matchVariable(cursor); ok {
+			cursor += len(t.text)
+			continue
+		}
+		if t, ok := l.matchInlineHeader(cursor, false); ok {
+			depth++
+			cursor += len(t.text)
+			continue
+		}
+		if strings.HasPrefix(l.source[cursor:], l.d.rightLoopBlock) {
+			depth--
+			if depth == 0 {
+				return true
+			}
+			cursor += len(l.d.rightLoopBlock)
+			continue
+		}
+		cursor++
+	}
+
+	return false
+}
+
+// Match a block footer at cursor : a line break followed by the closing block delimiter
+func (l *lexer) matchFooter(cursor int) (token, bool) {
+	start := cursor
+	lineBreak := l.lineBreak(cursor)
+	if lineBreak == 0 {
+		return token{}, false
+	}
+
+	cursor = l.skipSpaces(cursor+lineBreak, true)
+	if !strings.HasPrefix(l.source[cursor:], l.d.rightLoopBlock) {
+		return token{}, false
+	}
+	cursor += len(l.d.rightLoopBlock)
+
+	trimRight := strings.HasPrefix(l.source[cursor:], TrimMarker)
+	if trimRight {
+		cursor += len(TrimMarker)
+	}
+
+	return token{kind: footerToken, text: l.source[start:cursor], position: start, trimRight: trimRight}, true
+}
+
+// Match a variable placeholder at cursor. An escaped right delimiter is part of its content.
+func (l *lexer) matchVariable(cursor int) (token, bool) {
+	if !strings.HasPrefix(l.source[cursor:], l.d.left) {
+		return token{}, false
+	}
+
+	start := cursor + len(l.d.left)
+	end := start
+	for {
+		length := strings.Index(l.source[end:], l.d.right)
+		if length == -1 {
+			return token{}, false
+		}
+		end += length
+		if !strings.HasSuffix(l.source[start:end], EscapeCharacter) {
+			break
+		}
+		end += len(l.d.right)
+	}
+	value := strings.ReplaceAll(l.source[start:end], EscapeCharacter+l.d.right, l.d.right)
+	end += len(l.d.right)
+
+	t := token{kind: variableToken, text: l.source[cursor:end], position: cursor, valuePosition: start}
+	if len(value) > len(TrimMarker) && strings.HasPrefix(value, TrimMarker) && isSpace(value[len(TrimMarker)]) {
+		t.trimLeft = true
+		value = value[len(TrimMarker):]
+		t.valuePosition += len(TrimMarker)
+	}
+	if len(value) > len(TrimMarker) && strings.HasSuffix(value, TrimMarker) && isSpace(value[len(value)-len(TrimMarker)-1]) {
+		t.trimRight = true
+		value = value[:len(value)-len(TrimMarker)]
+	}
+	t.value = value
+
+	return t, true
+}
+
+// Match an escaped delimiter at cursor, returning the delimiter and the length of the escape sequence.
+// An escape character is only an escape before a delimiter starting a tag, and is plain content elsewhere, such as in \( -name x \) or C:\dir.
+// A doubled escape character preceding an escaped delimiter is a single literal escape character, the delimiter keeping its meaning.
+func (l *lexer) matchEscape(cursor int, inline bool) (text string, length int, ok bool) {
+	if !strings.HasPrefix(l.source[cursor:], EscapeCharacter) {
+		return "", 0, false
+	}
+
+	escaped := cursor + len(EscapeCharacter)
+	if strings.HasPrefix(l.source[escaped:], EscapeCharacter) {
+		if _, _, ok := l.matchEscape(escaped, inline); ok {
+			return EscapeCharacter, 2 * len(EscapeCharacter), true
+		}
+		return "", 0, false
+	}
+
+	delimiter := l.tagAt(escaped, l.startsLine(cursor), inline)
+	if delimiter == "" {
+		return "", 0, false
+	}
+
+	return delimiter, len(EscapeCharacter) + len(delimiter), true
+}
+
+// Whether only white spaces precede cursor on its line
+func (l *lexer) startsLine(cursor int) bool {
+	for cursor > 0 && (l.source[cursor-1] == ' ' || l.source[cursor-1] == '\t') {
+		cursor--
+	}
+
+	return cursor == 0 || l.source[cursor-1] == '\n'
+}
+
+// Delimiter starting a tag at cursor, empty when there is none : a placeholder, and when blocks are lexed a block header or footer.
+// Block headers and footers start a line, except the ones of inline blocks.
+func (l *lexer) tagAt(cursor int, lineStart bool, inline bool) string {
+	if _, ok := l.matchVariable(cursor); ok {
+		return l.d.left
+	}
+	if !l.blocks {
+		return ""
+	}
+
+	closing := strings.HasPrefix(l.source[cursor:], l.d.rightLoopBlock)
+	if lineStart {
+		if _, ok := l.matchHeader(cursor, false); ok {
+			return l.d.leftLoopVariable
+		}
+		if closing {
+			return l.d.rightLoopBlock
+		}
+	}
+	if closing && inline {
+		return l.d.rightLoopBlock
+	}
+	if t, ok := l.matchInlineHeader(cursor, false); ok && l.closesInline(t.position+len(t.text)) {
+		return l.d.leftLoopVariable
+	}
+
+	return ""
+}
+
+// Skip the body of a raw block, left untouched up to the footer closing it
+func (l *lexer) skipRaw() {
+	for l.cursor < len(l.source) {
+		if l.source[l.cursor] == '\n' || l.source[l.cursor] == '\r' {
+			if t, ok := l.matchFooter(l.cursor); ok {
+				l.emit(t)
+				return
+			}
+		}
+		l.cursor++
+	}
+}
+
+func (l *lexer) emit(t token) {
+	l.emitText(t.position)
+	l.tokens = append(l.tokens, t)
+	l.cursor = t.position + len(t.text)
+	l.textStart = l.cursor
+}
+
+// Emit the plain text read since the last token up to end
+func (l *lexer) emitText(end int) {
+	if l.textStart < end {
+		l.tokens = append(l.tokens, token{kind: textToken, text: l.source[l.textStart:end], position: l.textStart})
+	}
+}
+
+// Emit an escape sequence at cursor as the plain text it stands for
+func (l *lexer) emitEscape(text string, length int) {
+	l.emitText(l.cursor)
+	l.tokens = append(l.tokens, token{kind: textToken, text: text, position: l.cursor})
+	l.cursor += length
+	l.textStart = l.cursor
+}
+
+// Remove the white spaces of the text tokens next to the tags with trim markers, up to the first other character
+func trimTokens(tokens []token) []token {
+	for i, t := range tokens {
+		if t.trimLeft {
+			for j := i - 1; j >= 0 && tokens[j].kind == textToken; j-- {
+				tokens[j].text = strings.TrimRight(tokens[j].text, " \t\r\n")
+				if tokens[j].text != "" {
+					break
+				}
+			}
+		}
+		if t.trimRight {
+			for j := i + 1; j < len(tokens) && tokens[j].kind == textToken; j++ {
+				trimmed := strings.TrimLeft(tokens[j].text, " \t\r\n")
+				tokens[j].position += len(tokens[j].text) - len(trimmed)
+				tokens[j].text = trimmed
+				if trimmed != "" {
+					break
+				}
+			}
+		}
+	}
+
+	return tokens
+}
+
+// Split a template into tokens in a single pass. Block headers and footers are only tokens when blocks is set, plain text otherwise.
+// The body of a raw block is plain text, and so is an escaped delimiter which would otherwise start a tag.
+// An inline loop block is a header followed by its body, closed on the same line.
+func lex(source string, d *delimiters, blocks bool) []token {
+	l := &lexer{source: source, d: d, blocks: blocks}
+	lineStart := true
+
+	for l.cursor < len(l.source) {
+		if blocks && lineStart {
+			if t, ok := l.matchHeader(l.cursor, false); ok {
+				// the header ends its line
+				l.emit(t)
+				if keyword, _ := splitBlockHeader(t.value); keyword == RawKeyword {
+					l.skipRaw()
+				}
+				continue
+			}
+		}
+		lineStart = false
+
+		if blocks && (l.source[l.cursor] == '\n' || l.source[l.cursor] == '\r') {
+			if t, ok := l.matchFooter(l.cursor); ok {
+				l.emit(t)
+				if t, ok := l.matchHeader(l.cursor, true); ok && isChainHeader(t.value) {
+					l.emit(t)
+					lineStart = true
+				}
+				continue
+			}
+		}
+
+		if text, length, ok := l.matchEscape(l.cursor, l.inlineDepth > 0); ok {
+			l.emitEscape(text, length)
+			continue
+		}
+
+		if t, ok := l.matchVariable(l.cursor); ok {
+			l.emit(t)
+			continue
+		}
+
+		if blocks && l.inlineDepth > 0 && strings.HasPrefix(l.source[l.cursor:], l.d.rightLoopBlock) {
+			l.emit(token{kind: footerToken, text: l.d.rightLoopBlock, position: l.cursor, inline: true})
+			l.inlineDepth--
+			if t, ok := l.matchInlineHeader(l.cursor, true); ok && l.closesInline(t.position+len(t.text)) {
+				l.emit(t)
+				l.inlineDepth++
+			}
+			continue
+		}
+
+		if blocks {
+			if t, ok := l.matchInlineHeader(l.cursor, false); ok && l.closesInline(t.position+len(t.text)) {
+				l.emit(t)
+				l.inlineDepth++
+				continue
+			}
+		}
+
+		lineStart = l.source[l.cursor] == '\n'
+		l.cursor++
+	}
+
+	l.emitText(len(l.source))
+
+	return trimTokens(l.tokens)
+}
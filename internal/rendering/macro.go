@@ -0,0 +1,135 @@
+package rendering
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Keyword of a macro definition block such as (macro field name type)[, the macro being called from a placeholder such as {{ field("id", "int") }}
+const MacroKeyword = "macro"
+
+// Maximum depth of the macro calls, beyond which a macro is considered calling itself endlessly
+const MaxMacroDepth = 64
+
+// Macro bound to an execution of a template : its body renders with the state of the execution, seeing its arguments and the root variables
+type boundMacro struct {
+	macro *macroNode
+	state *renderState
+	root  *Scope
+}
+
+func (m *boundMacro) call(arguments []interface{}) (interface{}, error) {
+	if m.state.depth >= MaxMacroDepth {
+		return nil, fmt.Errorf("more than %d nested macro calls", MaxMacroDepth)
+	}
+
+	variables := make(map[string]interface{}, len(arguments))
+	for i, parameter := range m.macro.parameters {
+		variables[parameter] = arguments[i]
+	}
+
+	name, source := m.state.name, m.state.source
+	m.state.name, m.state.source = m.macro.template, m.macro.source
+	m.state.depth++
+	defer func() {
+		m.state.name, m.state.source = name, source
+		m.state.depth--
+	}()
+
+	var b strings.Builder
+	if err := renderOutput(&b, m.macro.body, NewScope(variables, m.root), m.state); err != nil {
+		return nil, locateError(err, m.macro.template, m.macro.source)
+	}
+
+	// the values inserted by the macro are already escaped
+	return rawValue{value: b.String()}, nil
+}
+
+// Macro of a scope, defined by the root scope of an execution
+func (s *Scope) macro(name string) (*boundMacro, bool) {
+	for scope := s; scope != nil; scope = scope.Parent {
+		if scope.macros != nil {
+			macro, ok := scope.macros[name]
+			return macro, ok
+		}
+	}
+
+	return nil, false
+}
+
+type callExpression struct {
+	name       string
+	arguments  []Expression
+	expression string
+	position   int
+}
+
+// A call of an unknown macro evaluates to null, its placeholder being left untouched
+func (e *callExpression) Evaluate(scope *Scope) (interface{}, error) {
+	macro, ok := scope.macro(e.name)
+	if !ok {
+		return nil, nil
+	}
+
+	if len(e.arguments) != len(macro.macro.parameters) {
+		return nil, newExpressionError(e.expression, e.position, fmt.Sprintf("macro %q expects %d argument(s), %d given", e.name, len(macro.macro.parameters), len(e.arguments)))
+	}
+
+	arguments := make([]interface{}, 0, len(e.arguments))
+	for _, argument := range e.arguments {
+		value, err := argument.Evaluate(scope)
+		if err != nil {
+			return nil, err
+		}
+		if raw, ok := value.(rawValue); ok {
+			value = raw.value
+		}
+		arguments = append(arguments, value)
+	}
+
+	value, err := macro.call(arguments)
+	if err != nil {
+		var located locatedError
+		if !errors.As(err, &located) {
+			return nil, newExpressionError(e.expression, e.position, fmt.Sprintf("macro %q : %v", e.name, err))
+		}
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// Collect the macros defined by nodes, the ones of the included templates being shared
+func collectMacros(macros map[string]*macroNode, nodes []Node, name string, source string) error {
+	var err error
+	walkNodes(nodes, name, source, func(node Node, template string, templateSource string) bool {
+		macro, ok := node.(*macroNode)
+		if !ok || err != nil {
+			return err == nil
+		}
+
+		if defined, ok := macros[macro.name]; ok && defined != macro {
+			if defined.template == template && defined.position == macro.position {
+				// the same template included more than once
+				return false
+			}
+			err = locateError(&SyntaxError{Location: Location{offset: macro.position}, Message: fmt.Sprintf("macro %q is already defined", macro.name)}, template, templateSource)
+			return false
+		}
+
+		macro.template, macro.source = template, templateSource
+		macros[macro.name] = macro
+		return false
+	})
+
+	return err
+}
+
+// Bind the macros of a template to an execution
+func bindMacros(macros map[string]*macroNode, state *renderState, root *Scope) {
+	root.macros = make(map[string]*boundMacro, len(macros))
+	for name, macro := range macros {
+		root.macros[name] = &boundMacro{macro: macro, state: state, root: root}
+	}
+}
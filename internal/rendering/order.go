@@ -0,0 +1,68 @@
+package rendering
+
+import (
+	"reflect"
+	"sort"
+)
+
+// Orders of the entries of the maps iterated by the loop blocks
+const (
+	SortedKeyOrder    = "sorted"
+	InsertionKeyOrder = "insertion"
+)
+
+// Order of the entries of the maps iterated by the loop blocks, given to an execution along with the data it orders
+type KeyOrder interface {
+	// Keys of a map in iteration order
+	Keys(entries map[string]interface{}) []string
+}
+
+// Keys of a map sorted in lexical order, the order of the loop blocks when no key order is set
+func sortedKeys(entries map[string]interface{}) []string {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+type insertionObject struct {
+	// kept to identify the map, its address not being reused while it is referenced
+	entries map[string]interface{}
+	keys    []string
+}
+
+// Order of the keys of the objects of a data source, such as a JSON file, each decoded map being added with the keys in the order of the source.
+// The maps not added, such as the ones built by the filters, are iterated in lexical order. The objects are added before the executions.
+type InsertionOrder struct {
+	objects map[uintptr]insertionObject
+}
+
+func NewInsertionOrder() *InsertionOrder {
+	return &InsertionOrder{objects: make(map[uintptr]insertionObject)}
+}
+
+// Add a decoded map with its keys in the order of the source
+func (o *InsertionOrder) Add(entries map[string]interface{}, keys []string) {
+	o.objects[reflect.ValueOf(entries).Pointer()] = insertionObject{entries: entries, keys: append([]string{}, keys...)}
+}
+
+func (o *InsertionOrder) Keys(entries map[string]interface{}) []string {
+	object, ok := o.objects[reflect.ValueOf(entries).Pointer()]
+	if !ok || len(object.keys) != len(entries) {
+		return sortedKeys(entries)
+	}
+
+	keys := make([]string, 0, len(entries))
+	for _, key := range object.keys {
+		if _, ok := entries[key]; !ok {
+			// the map changed since it was added
+			return sortedKeys(entries)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys
+}
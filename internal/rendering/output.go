@@ -0,0 +1,77 @@
+package rendering
+
+import (
+	"io"
+	"strings"
+)
+
+// Length beyond which the line being written is shortened to its indentation and its end, the only parts the structured output modes look at
+const maxLineLength = 1024
+
+// Output of a rendering, written to a writer as the nodes are rendered.
+// The first write error stops the rendering, and the end of the line being written is kept for the structured output modes.
+// The white spaces ending the output are written with the text following them, or dropped before a block starting on the next line.
+type output struct {
+	w       io.Writer
+	line    string
+	pending string
+	err     error
+}
+
+func newOutput(w io.Writer) *output {
+	return &output{w: w}
+}
+
+// Render nodes to a writer, the white spaces ending the output included
+func renderOutput(w io.Writer, nodes []Node, scope *Scope, state *renderState) error {
+	out := newOutput(w)
+	if err := renderNodes(out, nodes, scope, state); err != nil {
+		return err
+	}
+
+	return out.flush()
+}
+
+func (o *output) WriteString(s string) {
+	if o.err != nil || s == "" {
+		return
+	}
+
+	if text := strings.TrimRight(s, " \t"); text != "" {
+		_, o.err = io.WriteString(o.w, o.pending+text)
+		o.pending = s[len(text):]
+	} else {
+		o.pending += s
+	}
+
+	if i := strings.LastIndexByte(s, '\n'); i != -1 {
+		o.line = s[i+1:]
+	} else {
+		o.line += s
+	}
+	if len(o.line) > maxLineLength {
+		indentation := lineIndentation(o.line)
+		o.line = indentation + strings.TrimLeft(o.line[len(o.line)-maxLineLength/2:], " \t")
+	}
+}
+
+// Drop the white spaces ending the output, such as the one following a key whose value is a block starting on the next line
+func (o *output) trimSpaces() {
+	o.line = strings.TrimRight(o.line, " \t")
+	o.pending = ""
+}
+
+// Write the white spaces ending the output
+func (o *output) flush() error {
+	if o.err == nil && o.pending != "" {
+		_, o.err = io.WriteString(o.w, o.pending)
+		o.pending = ""
+	}
+
+	return o.err
+}
+
+// Text of the output line being written, shortened when it is long
+func (o *output) currentLine() string {
+	return o.line
+}
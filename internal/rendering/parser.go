@@ -0,0 +1,537 @@
+package rendering
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Keywords opening the branches of a condition block
+const (
+	IfKeyword   = "if"
+	ElifKeyword = "elif"
+	ElseKeyword = "else"
+)
+
+// Marker of a comment placeholder, removed from the output : {{# comment }}
+const CommentMarker = "#"
+
+// Keyword of a raw block, whose body is rendered verbatim
+const RawKeyword = "raw"
+
+// Keyword of a named block, which the templates extending the one defining it can override
+const BlockKeyword = "block"
+
+// Node of a parsed template
+type Node interface {
+	render(out *output, scope *Scope, state *renderState) error
+}
+
+type textNode struct {
+	text string
+}
+
+// the positions of the nodes are the offsets of their variable or expression in the source of the template
+type variableNode struct {
+	placeholder *placeholder
+	text        string
+	position    int
+	// whether the placeholder ends the value it sits in, its value being serialized by the structured output modes
+	closesValue bool
+}
+
+type loopNode struct {
+	variable string
+	joiner   string
+	body     []Node
+	position int
+	// source of an inline loop block, left untouched when its variable is not a list or a map
+	inline bool
+	text   string
+	// else branch rendered when there are no elements
+	otherwise []Node
+	hasElse   bool
+	// line break of the loop line, only rendered when something is rendered
+	leading  string
+	trailing string
+}
+
+// nodes parsed from another template, such as an included one, rendered with the variables of the enclosing one
+type templateNode struct {
+	nodes  []Node
+	name   string
+	source string
+}
+
+// named block of a template, its body being replaced by the one of the template extending it
+type blockNode struct {
+	name       string
+	body       []Node
+	position   int
+	overridden bool
+}
+
+// extends placeholder of a template, replaced with the template it extends once its blocks are overridden
+type extendsNode struct {
+	name     string
+	position int
+}
+
+// content of the block overridden by the block of an extending template
+type superNode struct {
+	parent []Node
+}
+
+// macro definition, rendering nothing where it is defined
+type macroNode struct {
+	name       string
+	parameters []string
+	body       []Node
+	position   int
+	// template defining the macro
+	template string
+	source   string
+}
+
+type branchNode struct {
+	keyword    string
+	expression Expression
+	body       []Node
+	position   int
+}
+
+type conditionNode struct {
+	branches []*branchNode
+	// line break of the condition line, only rendered when a branch is selected
+	leading  string
+	trailing string
+}
+
+// Split a block header into its keyword and the expression following it ( no keyword for loop headers )
+func splitBlockHeader(header string) (keyword string, expression string) {
+	trimmed := strings.TrimSpace(header)
+	keyword = trimmed
+	if i := strings.IndexAny(trimmed, " \t"); i != -1 {
+		keyword = trimmed[:i]
+		expression = strings.TrimSpace(trimmed[i:])
+	}
+
+	switch keyword {
+	case IfKeyword, ElifKeyword, ElseKeyword, RawKeyword, BlockKeyword, MacroKeyword:
+		return keyword, expression
+	default:
+		return "", header
+	}
+}
+
+func isChainHeader(header string) bool {
+	keyword, _ := splitBlockHeader(header)
+	return keyword == ElifKeyword || keyword == ElseKeyword
+}
+
+// Index of the footer closing the block opened by the header at start, -1 if the block is not closed
+func closingFooter(tokens []token, start int) int {
+	depth := 0
+	for i := start; i < len(tokens); i++ {
+		switch tokens[i].kind {
+		case headerToken:
+			depth++
+		case footerToken:
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// Number of spaces indenting the first line of a block
+func leadingSpaces(tokens []token) int {
+	spaces := 0
+	for _, t := range tokens {
+		switch t.kind {
+		case textToken:
+			count := CountLeadingWhitespaces(t.text)
+			spaces += count
+			if count < len(t.text) {
+				return spaces
+			}
+		case headerToken:
+			return spaces + CountLeadingWhitespaces(t.text)
+		default:
+			return spaces
+		}
+	}
+
+	return spaces
+}
+
+// Reindent the tokens of a block to the offset of its header, removing the same number of characters at the beginning of each line, and trim the line breaks ending it
+func reindentTokens(tokens []token, offset int) []token {
+	delta := leadingSpaces(tokens) - offset
+	if delta < 0 {
+		delta = 0
+	}
+
+	reindented := make([]token, 0, len(tokens))
+	skip := delta
+	for _, t := range tokens {
+		switch t.kind {
+		case textToken:
+			var b strings.Builder
+			for i := 0; i < len(t.text); i++ {
+				if skip > 0 {
+					skip--
+					continue
+				}
+				b.WriteByte(t.text[i])
+				if t.text[i] == '\n' {
+					skip = delta
+				}
+			}
+			t.text = b.String()
+		case headerToken:
+			if !t.chained && skip > 0 {
+				removed := skip
+				if removed > t.offset {
+					removed = t.offset
+				}
+				t.text = t.text[removed:]
+				t.position += removed
+				t.offset -= removed
+			}
+			// the header ends its line
+			skip = delta
+			reindented = append(reindented, t)
+			continue
+		}
+
+		if t.kind != textToken {
+			skip = 0
+		}
+		reindented = append(reindented, t)
+	}
+
+	if last := len(reindented) - 1; last >= 0 && reindented[last].kind == textToken {
+		reindented[last].text = strings.TrimRight(reindented[last].text, "\n\r")
+	}
+
+	return reindented
+}
+
+type parser struct {
+	tokens []token
+	cursor int
+	nodes  []Node
+	// nil when include placeholders are plain variables
+	includes *includer
+}
+
+// Parse a block of tokens into nodes
+func parseTokens(tokens []token, includes *includer) ([]Node, error) {
+	p := &parser{tokens: tokens, includes: includes}
+
+	for p.cursor < len(p.tokens) {
+		t := p.tokens[p.cursor]
+
+		switch t.kind {
+		case textToken:
+			p.appendText(t.text)
+			p.cursor++
+		case variableToken:
+			if strings.HasPrefix(strings.TrimSpace(t.value), CommentMarker) {
+				p.cursor++
+				continue
+			}
+
+			position := t.valuePosition + len(t.value) - len(strings.TrimLeft(t.value, " \t\r\n"))
+			if name, ok := directiveName(t.value, IncludeMarker); ok && p.includes != nil {
+				node, err := p.includes.include(name, position)
+				if err != nil {
+					return nil, err
+				}
+				p.nodes = append(p.nodes, node)
+				p.cursor++
+				continue
+			}
+			if name, ok := directiveName(t.value, ExtendsMarker); ok && p.includes != nil {
+				p.nodes = append(p.nodes, &extendsNode{name: name, position: position})
+				p.cursor++
+				continue
+			}
+			if strings.TrimSpace(t.value) == SuperVariable && p.includes != nil {
+				p.nodes = append(p.nodes, &superNode{})
+				p.cursor++
+				continue
+			}
+
+			placeholder := compilePlaceholder(t.value)
+			if placeholder.required && placeholder.err != nil {
+				return nil, moveError(placeholder.err, position)
+			}
+
+			closes := p.cursor+1 >= len(p.tokens) || p.tokens[p.cursor+1].kind == footerToken
+			if !closes && p.tokens[p.cursor+1].kind == textToken {
+				closes = closesValue(p.tokens[p.cursor+1].text)
+			}
+
+			p.nodes = append(p.nodes, &variableNode{placeholder: placeholder, text: t.text, position: position, closesValue: closes})
+			p.cursor++
+		case footerToken:
+			// closing delimiter outside of any block is plain content
+			p.appendText(t.text)
+			p.cursor++
+		case headerToken:
+			if err := p.parseBlock(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return p.nodes, nil
+}
+
+func (p *parser) appendText(text string) {
+	if text == "" {
+		return
+	}
+	if last, ok := p.lastText(); ok {
+		last.text += text
+		return
+	}
+	p.nodes = append(p.nodes, &textNode{text: text})
+}
+
+func (p *parser) lastText() (*textNode, bool) {
+	if len(p.nodes) == 0 {
+		return nil, false
+	}
+	last, ok := p.nodes[len(p.nodes)-1].(*textNode)
+	return last, ok
+}
+
+// Offset of the left loop variable delimiter of a header in the source
+func headerStart(header token) int {
+	return header.position + len(header.text) - len(strings.TrimLeft(header.text, " \t\r\n"))
+}
+
+func syntaxError(header token, format string, args ...interface{}) error {
+	return &SyntaxError{Location: Location{offset: headerStart(header)}, Message: fmt.Sprintf(format, args...)}
+}
+
+// Parse the block opened by the header at the cursor : a loop, a chain of condition branches, a raw block, a named block or a macro definition
+func (p *parser) parseBlock() error {
+	header := p.tokens[p.cursor]
+	keyword, _ := splitBlockHeader(header.value)
+
+	if header.chained || keyword == ElifKeyword || keyword == ElseKeyword {
+		return syntaxError(header, "%q branch without a preceding %q branch", keyword, IfKeyword)
+	}
+
+	end := closingFooter(p.tokens, p.cursor)
+	if end == -1 {
+		return &UnclosedBlockError{Location: Location{offset: headerStart(header)}, Block: header.value}
+	}
+
+	if keyword == RawKeyword {
+		_, expression := splitBlockHeader(header.value)
+		if expression != "" {
+			return syntaxError(header, "%q block does not take an expression", RawKeyword)
+		}
+
+		// the lexer left the body as plain text
+		for _, t := range reindentTokens(p.tokens[p.cursor+1:end], header.offset) {
+			p.appendText(t.text)
+		}
+		p.cursor = end + 1
+		return nil
+	}
+
+	if keyword == BlockKeyword {
+		_, name := splitBlockHeader(header.value)
+		if name == "" || strings.ContainsAny(name, " \t") {
+			return syntaxError(header, "%q block without a single name", BlockKeyword)
+		}
+
+		body, err := parseTokens(reindentTokens(p.tokens[p.cursor+1:end], header.offset), p.includes)
+		if err != nil {
+			return err
+		}
+
+		p.nodes = append(p.nodes, &blockNode{name: name, body: body, position: headerStart(header)})
+		p.cursor = end + 1
+		return nil
+	}
+
+	if keyword == MacroKeyword {
+		_, definition := splitBlockHeader(header.value)
+		fields := strings.FieldsFunc(definition, func(r rune) bool { return r == ' ' || r == '\t' || r == ',' })
+		if len(fields) == 0 {
+			return syntaxError(header, "%q block without a name", MacroKeyword)
+		}
+
+		body, err := parseTokens(reindentTokens(p.tokens[p.cursor+1:end], header.offset), p.includes)
+		if err != nil {
+			return err
+		}
+
+		p.nodes = append(p.nodes, &macroNode{name: fields[0], parameters: fields[1:], body: body, position: headerStart(header)})
+		p.cursor = end + 1
+		// the line of the definition is removed
+		p.takeLineBreak()
+		return nil
+	}
+
+	if header.inline {
+		body, err := parseTokens(p.tokens[p.cursor+1:end], p.includes)
+		if err != nil {
+			return err
+		}
+
+		var text strings.Builder
+		for _, t := range p.tokens[p.cursor : end+1] {
+			text.WriteString(t.text)
+		}
+
+		loop := &loopNode{variable: header.value, joiner: header.joiner, body: body, position: header.valuePosition, inline: true, text: text.String()}
+		p.cursor = end + 1
+		if err := p.parseLoopElse(loop, header); err != nil {
+			return err
+		}
+
+		p.nodes = append(p.nodes, loop)
+		return nil
+	}
+
+	if keyword == "" {
+		body, err := parseTokens(reindentTokens(p.tokens[p.cursor+1:end], header.offset), p.includes)
+		if err != nil {
+			return err
+		}
+
+		loop := &loopNode{variable: header.value, joiner: header.joiner, body: body, position: header.valuePosition}
+		p.cursor = end + 1
+		if err := p.parseLoopElse(loop, header); err != nil {
+			return err
+		}
+
+		// when nothing is rendered the line of the loop block is removed
+		loop.leading, loop.trailing = p.takeLineBreak()
+
+		p.nodes = append(p.nodes, loop)
+		return nil
+	}
+
+	condition := &conditionNode{}
+	for {
+		branchHeader := p.tokens[p.cursor]
+		keyword, expression := splitBlockHeader(branchHeader.value)
+		if keyword != ElseKeyword && expression == "" {
+			return syntaxError(branchHeader, "%q branch without a condition", keyword)
+		}
+		if n := len(condition.branches); n > 0 && condition.branches[n-1].keyword == ElseKeyword {
+			return syntaxError(branchHeader, "%q branch following an %q branch", keyword, ElseKeyword)
+		}
+
+		branch := &branchNode{
+			keyword:  keyword,
+			position: branchHeader.valuePosition + len(strings.TrimRight(branchHeader.value, " \t\r\n")) - len(expression),
+		}
+		if keyword != ElseKeyword {
+			parsed, err := ParseExpression(expression)
+			if err != nil {
+				return moveError(err, branch.position)
+			}
+			branch.expression = parsed
+		}
+
+		body, err := parseTokens(reindentTokens(p.tokens[p.cursor+1:end], header.offset), p.includes)
+		if err != nil {
+			return err
+		}
+		branch.body = body
+		condition.branches = append(condition.branches, branch)
+
+		p.cursor = end + 1
+		if p.cursor >= len(p.tokens) || p.tokens[p.cursor].kind != headerToken || !p.tokens[p.cursor].chained {
+			break
+		}
+		if end = closingFooter(p.tokens, p.cursor); end == -1 {
+			next := p.tokens[p.cursor]
+			return &UnclosedBlockError{Location: Location{offset: headerStart(next)}, Block: next.value}
+		}
+	}
+
+	// when no branch is selected the line of the condition block is removed
+	condition.leading, condition.trailing = p.takeLineBreak()
+
+	p.nodes = append(p.nodes, condition)
+	return nil
+}
+
+// Parse the else branch chained to a loop block at the cursor, if any
+func (p *parser) parseLoopElse(loop *loopNode, header token) error {
+	if p.cursor >= len(p.tokens) || p.tokens[p.cursor].kind != headerToken || !p.tokens[p.cursor].chained {
+		return nil
+	}
+
+	branchHeader := p.tokens[p.cursor]
+	if keyword, expression := splitBlockHeader(branchHeader.value); keyword != ElseKeyword || expression != "" {
+		return syntaxError(branchHeader, "%q branch following a loop block", branchHeader.value)
+	}
+
+	end := closingFooter(p.tokens, p.cursor)
+	if end == -1 {
+		return &UnclosedBlockError{Location: Location{offset: headerStart(branchHeader)}, Block: branchHeader.value}
+	}
+
+	tokens := p.tokens[p.cursor+1 : end]
+	if !header.inline {
+		tokens = reindentTokens(tokens, header.offset)
+	}
+	otherwise, err := parseTokens(tokens, p.includes)
+	if err != nil {
+		return err
+	}
+
+	if header.inline {
+		for _, t := range p.tokens[p.cursor : end+1] {
+			loop.text += t.text
+		}
+	}
+	loop.otherwise, loop.hasElse = otherwise, true
+	p.cursor = end + 1
+	return nil
+}
+
+// Take the line break ending the line of the block preceding the cursor : the line break following it, or the one preceding it at the end of a block
+func (p *parser) takeLineBreak() (leading string, trailing string) {
+	if p.cursor < len(p.tokens) && (p.tokens[p.cursor].kind == textToken || p.tokens[p.cursor].kind == footerToken) {
+		next := p.tokens[p.cursor].text
+		for _, lineBreak := range []string{"\r\n", "\n"} {
+			if strings.HasPrefix(next, lineBreak) {
+				p.tokens[p.cursor].text = next[len(lineBreak):]
+				return "", lineBreak
+			}
+		}
+	}
+
+	if last, ok := p.lastText(); ok {
+		trimmed := strings.TrimSuffix(strings.TrimSuffix(last.text, "\n"), "\r")
+		leading = last.text[len(trimmed):]
+		last.text = trimmed
+	}
+
+	return leading, ""
+}
+
+// Parse a template into nodes, in a single pass over its source.
+// Loops and conditions are blocks whose header wraps their variable or condition with the loop variable delimiters, followed by the opening loop block delimiter ending the line.
+// The expression of a condition can nest the loop variable delimiters, such as parentheses grouping its operands, and contain them in quoted strings, as long as it stays on the header line.
+// A delimiter preceded by the escape character is plain content, and so is the body of a raw block up to the footer closing it.
+// Include placeholders are replaced with the parsed templates they include, and comment placeholders are removed.
+// Block header and footer lines are removed and block bodies reindented, other white spaces being only removed by trim markers.
+func parse(template string, d *delimiters, includes *includer) ([]Node, error) {
+	return parseTokens(lex(template, d, true), includes)
+}
@@ -0,0 +1,125 @@
+package rendering
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Split a variable path such as customer.address.city or items[0].name into its segments ( string keys and int indexes )
+func SplitPath(path string) []interface{} {
+	var segments []interface{}
+	var cursor int
+
+	// a leading dot refers to the current loop element
+	if strings.HasPrefix(path, CurrentElementVariable) {
+		segments = append(segments, CurrentElementVariable)
+		cursor = len(CurrentElementVariable)
+		if cursor < len(path) && path[cursor] != '[' {
+			path = path[:cursor] + "." + path[cursor:]
+		}
+	} else {
+		end := strings.IndexAny(path, ".[")
+		if end == -1 {
+			end = len(path)
+		}
+		segments = append(segments, path[:end])
+		cursor = end
+	}
+
+	for cursor < len(path) {
+		if path[cursor] == '[' {
+			end := strings.IndexByte(path[cursor:], ']')
+			if end == -1 {
+				end = len(path) - cursor
+			}
+
+			index := strings.TrimSpace(path[cursor+1 : cursor+end])
+			if i, err := strconv.Atoi(index); err == nil {
+				segments = append(segments, i)
+			} else {
+				segments = append(segments, strings.Trim(index, "\"'"))
+			}
+
+			cursor += end + 1
+		} else {
+			// skip the dot separator
+			cursor++
+			end := strings.IndexAny(path[cursor:], ".[")
+			if end == -1 {
+				end = len(path) - cursor
+			}
+
+			segments = append(segments, path[cursor:cursor+end])
+			cursor += end
+		}
+	}
+
+	return segments
+}
+
+// Resolve a variable path against a map of variables
+func ResolvePath(variables map[string]interface{}, path string) (value interface{}, found bool) {
+	// a key matching the full path wins over the path segments ( keys may contain dots )
+	if value, found = variables[path]; found {
+		return
+	}
+
+	segments := SplitPath(path)
+	value, found = variables[segments[0].(string)]
+	if !found {
+		return nil, false
+	}
+
+	for _, segment := range segments[1:] {
+		switch s := segment.(type) {
+		case int:
+			list, ok := value.([]interface{})
+			if !ok || s < 0 || s >= len(list) {
+				return nil, false
+			}
+			value = list[s]
+		case string:
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if value, found = object[s]; !found {
+				return nil, false
+			}
+		}
+	}
+
+	return value, true
+}
+
+// Variables of a template, shadowing the ones of the enclosing scope ( the variables of a loop element shadow the ones of the enclosing loops )
+type Scope struct {
+	Variables map[string]interface{}
+	Parent    *Scope
+	// macros of the template being executed, set on the root scope
+	macros map[string]*boundMacro
+}
+
+func NewScope(variables map[string]interface{}, parent *Scope) *Scope {
+	return &Scope{Variables: variables, Parent: parent}
+}
+
+// Resolve a variable path against the innermost scope defining its root
+func (s *Scope) Resolve(path string) (value interface{}, found bool) {
+	var root string
+
+	for scope := s; scope != nil; scope = scope.Parent {
+		if value, found = scope.Variables[path]; found {
+			return
+		}
+
+		if root == "" {
+			root = SplitPath(path)[0].(string)
+		}
+		if _, found = scope.Variables[root]; found {
+			return ResolvePath(scope.Variables, path)
+		}
+	}
+
+	return nil, false
+}
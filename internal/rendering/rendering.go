@@ -2,107 +2,46 @@ package rendering
 
 import (
 	"fmt"
-	"regexp"
+	"sort"
 	"strings"
-
-	"github.com/sebps/template-engine/internal/utils"
 )
 
-type Loop struct {
-	StartIndex int
-	EndIndex   int
-	Variable   string
-	Values     []map[string]interface{}
-	Block      string
-	Joiner     string
-	Offset     int
-}
-
-// Parse the loops blocks into a structure
-func ParseLoops(
-	structure string,
-	variables map[string]interface{},
-	leftLoopVariableDelimiter string,
-	rightLoopVariableDelimiter string,
-	leftLoopBlockDelimiter string,
-	rightLoopBlockDelimiter string,
-) []*Loop {
-	// TODO:
-	// 1- Handle the case in which loop.Values is a slice of primitive such as string, int, float, bool
-	// 2- Handle infinite recursion
-	var loops []*Loop
-
-	// old "(?sm)(?P<loop>(?P<offset>^\\s*)%s(?P<variable>[a-zA-Z0-9\\-\\_]+)%s%s\n*(?P<block>[^%s]*)\n\\s*%s)",
-	// test loopsRegexString = "(?sm)(?P<loop>(?P<offset>^\\s*)\\((?P<variable>[a-zA-Z0-9\\-\\_]+)\\)\\[\\[\n*(?P<block>[^\\]\\]]*)\n\\s*\\]\\])"
-
-	variableWrapper := utils.GenerateWrapperRegexp(
-		leftLoopVariableDelimiter,
-		rightLoopVariableDelimiter,
-		"variable",
-		false,
-	)
-
-	joinerWrapper := utils.GenerateWrapperRegexp(
-		leftLoopVariableDelimiter,
-		rightLoopVariableDelimiter,
-		"joiner",
-		false,
-	)
-
-	blockWrapper := utils.GenerateWrapperRegexp(
-		leftLoopBlockDelimiter,
-		rightLoopBlockDelimiter,
-		"block",
-		true,
-	)
-
-	loopsRegexString := fmt.Sprintf(
-		"(?sm)(?P<loop>(?P<offset>^\\s*)%s(%s)?%s)",
-		variableWrapper,
-		joinerWrapper,
-		blockWrapper,
-	)
-
-	loopsRegexp := regexp.MustCompile(loopsRegexString)
-	loopsGroupNames := loopsRegexp.SubexpNames()
-	submatchIndexes := loopsRegexp.FindAllStringSubmatchIndex(structure, -1)
-
-	for loopIdx, loopMatch := range loopsRegexp.FindAllStringSubmatch(structure, -1) {
-		loop := &Loop{
-			Joiner: "\n",
-		}
-
-		for loopGroupIdx, loopGroupContent := range loopMatch {
-			name := loopsGroupNames[loopGroupIdx]
-			if name == "loop" {
-				loop.EndIndex = submatchIndexes[loopIdx][2*loopGroupIdx+1]
-				loop.StartIndex = submatchIndexes[loopIdx][2*loopGroupIdx]
-			} else if name == "offset" {
-				loop.Offset = len(loopGroupContent)
-			} else if name == "variable" {
-				loop.Variable = loopGroupContent
-			} else if name == "block" {
-				loop.Block = loopGroupContent
-			} else if name == "joiner" {
-				loop.Joiner = loopGroupContent
-			}
-		}
+// Name of the variable holding the current element inside a loop block
+const CurrentElementVariable = "."
+
+// Names of the metadata variables of the current element inside a loop block
+const (
+	IndexVariable  = "@index"
+	NumberVariable = "@number"
+	FirstVariable  = "@first"
+	LastVariable   = "@last"
+	LengthVariable = "@length"
+	EvenVariable   = "@even"
+	OddVariable    = "@odd"
+)
 
-		if variable := variables[loop.Variable]; variable != nil {
-			loop.Values = make([]map[string]interface{}, 0)
-			for i, e := range variable.([]interface{}) {
-				eCast := e.(map[string]interface{})
-				loop.Values = append(loop.Values, make(map[string]interface{}))
-				for k, v := range eCast {
-					loop.Values[i][k] = v
-				}
-			}
-		}
+// Names of the key and value variables of the current entry inside a loop block iterating over a map, the current element being the value
+const (
+	KeyVariable   = "@key"
+	ValueVariable = "@value"
+)
 
-		loops = append(loops, loop)
+// Metadata of the element at a zero-based index of a loop, the parity being the one of the zero-based index.
+// The length of the elements of an Iterable is not known : it is left out when negative.
+func loopMetadata(idx int, last bool, length int) map[string]interface{} {
+	metadata := map[string]interface{}{
+		IndexVariable:  idx,
+		NumberVariable: idx + 1,
+		FirstVariable:  idx == 0,
+		LastVariable:   last,
+		EvenVariable:   idx%2 == 0,
+		OddVariable:    idx%2 == 1,
+	}
+	if length >= 0 {
+		metadata[LengthVariable] = length
 	}
 
-	return loops
+	return metadata
 }
 
 func CountLeadingWhitespaces(s string) int {
@@ -121,126 +60,348 @@ func CountLeadingWhitespaces(s string) int {
 	return spaces
 }
 
-// Reindent a string to the required offset
-func Reindent(content string, baseOffset int) string {
-	var result string
-	var contentOffset int
-	var deltaOffset int
+type missingKey struct {
+	template string
+	offset   int
+}
+
+// State of a rendering : the placeholders replaced and the ones left untouched because their variables were not found
+type renderState struct {
+	// template being rendered, changing in included templates
+	name   string
+	source string
+
+	replacements int
+	missing      []*MissingVariableError
+	// placeholders already missing, by template and offset, a placeholder of a loop block being missing once for all the elements
+	missingOffsets map[missingKey]bool
+	// escaping of the inserted values, nil for none
+	escape func(string) string
+	// depth of the macro calls being rendered
+	depth int
+	// whether a loop variable not found in the data is an error
+	panicIfMissingLoop bool
+	// order of the entries of the maps iterated by the loop blocks, nil for the lexical order
+	keyOrder KeyOrder
+	// structured output mode serializing the inserted values, none to write them as text
+	structured string
+}
 
-	contentOffset = CountLeadingWhitespaces(content)
-	deltaOffset = contentOffset - baseOffset
+// Keys of a map iterated by a loop block, in the key order of the rendering
+func (s *renderState) keys(entries map[string]interface{}) []string {
+	if s.keyOrder == nil {
+		return sortedKeys(entries)
+	}
 
-	for cursor := 0; cursor < len(content); cursor++ {
-		if cursor == 0 && "\n" != string(content[cursor]) {
-			for i := 0; i < deltaOffset; i++ {
-				cursor++
-			}
+	return s.keyOrder.Keys(entries)
+}
+
+// Add a missing placeholder, located in the template being rendered
+func (s *renderState) addMissing(variable string, offset int) {
+	key := missingKey{template: s.name, offset: offset}
+	if s.missingOffsets == nil {
+		s.missingOffsets = make(map[missingKey]bool)
+	}
+	if s.missingOffsets[key] {
+		return
+	}
+
+	s.missingOffsets[key] = true
+	missing := &MissingVariableError{Location: Location{offset: offset}, Variable: variable}
+	locateError(missing, s.name, s.source)
+	s.missing = append(s.missing, missing)
+}
+
+// Report of the missing placeholders sorted by position, the ones of a template following the ones of the templates found before it
+func (s *renderState) report() *Report {
+	templates := make(map[string]int)
+	for _, missing := range s.missing {
+		if _, ok := templates[missing.Template]; !ok {
+			templates[missing.Template] = len(templates)
 		}
+	}
+
+	report := &Report{Missing: append([]*MissingVariableError{}, s.missing...)}
+	sort.SliceStable(report.Missing, func(i, j int) bool {
+		a, b := report.Missing[i], report.Missing[j]
+		if a.Template != b.Template {
+			return templates[a.Template] < templates[b.Template]
+		}
+		return a.offset < b.offset
+	})
+
+	return report
+}
+
+func (n *textNode) render(out *output, scope *Scope, state *renderState) error {
+	out.WriteString(n.text)
+	return nil
+}
 
-		result += string(content[cursor])
+func (n *variableNode) render(out *output, scope *Scope, state *renderState) error {
+	v, found, err := n.placeholder.evaluate(scope)
+	if err != nil {
+		return moveError(err, n.position)
+	}
 
-		if "\n" == string(content[cursor]) {
-			for i := 0; i < deltaOffset; i++ {
-				cursor++
+	if !found {
+		state.addMissing(n.placeholder.content, n.position)
+		out.WriteString(n.text)
+		return nil
+	}
+
+	state.replacements++
+	if raw, ok := v.(rawValue); ok {
+		out.WriteString(formatValue(raw.value))
+		return nil
+	}
+
+	escape := state.escape
+	if state.structured != NoStructured && state.structured != "" {
+		// the values written outside of a value position, such as in a quoted string, are escaped as JSON strings when no escaping is set
+		if escape == nil {
+			escape = escapeJSON
+		}
+		text, escapeText, ok, err := structuredValue(v, state.structured, out.currentLine(), n.closesValue)
+		if err != nil {
+			return &TypeMismatchError{Location: Location{offset: n.position}, Message: fmt.Sprintf("cannot serialize %T : %v", v, err)}
+		}
+		if ok {
+			if escapeText {
+				text = escape(text)
+			}
+			if strings.HasPrefix(text, "\n") {
+				// a block nested under the key or the list dash written before it
+				out.trimSpaces()
 			}
+			out.WriteString(text)
+			return nil
 		}
 	}
 
-	return result
+	value := formatValue(v)
+	if escape != nil {
+		value = escape(value)
+	}
+	out.WriteString(value)
+	return nil
 }
 
-// Flattify the base structure transforming the loops block into flat content
-func FlattifyStructure(structure string, loops []*Loop, leftDelimiter string, rightDelimiter string) string {
-	var rendered []byte
-	var cursor int
+func (n *templateNode) render(out *output, scope *Scope, state *renderState) error {
+	name, source := state.name, state.source
+	state.name, state.source = n.name, n.source
+	defer func() {
+		state.name, state.source = name, source
+	}()
 
-	for _, loop := range loops {
-		for cursor < loop.StartIndex {
-			rendered = append(rendered, structure[cursor])
-			cursor++
-		}
+	if err := renderNodes(out, n.nodes, scope, state); err != nil {
+		return locateError(err, n.name, n.source)
+	}
 
-		var loopBlocks []string
-		var loopRendered string
+	return nil
+}
 
-		for idx, value := range loop.Values {
-			// TODO: handle the case in which loop.Values is a slice of primitive such as string, int, float, bool
-			var mapping = make(map[string]string)
+func (n *blockNode) render(out *output, scope *Scope, state *renderState) error {
+	return renderNodes(out, n.body, scope, state)
+}
 
-			for k := range value {
-				mapping[k] = loop.Variable + "_" + k + "_" + fmt.Sprint(idx)
-			}
+func (n *extendsNode) render(out *output, scope *Scope, state *renderState) error {
+	// replaced with the extended template at compilation
+	return nil
+}
+
+func (n *macroNode) render(out *output, scope *Scope, state *renderState) error {
+	// rendered where the macro is called
+	return nil
+}
+
+func (n *superNode) render(out *output, scope *Scope, state *renderState) error {
+	return renderNodes(out, n.parent, scope, state)
+}
+
+func (n *loopNode) render(out *output, scope *Scope, state *renderState) error {
+	variable, found := scope.Resolve(n.variable)
+	if !found && !n.hasElse && state.panicIfMissingLoop {
+		return &MissingVariableError{Location: Location{offset: n.position}, Variable: n.variable}
+	}
+
+	if iterable, ok := variable.(Iterable); ok {
+		return n.renderIterable(out, scope, state, iterable)
+	}
 
-			loopBlock := RenameVariables(loop.Block, mapping, leftDelimiter, rightDelimiter)
-			indentedLoopBlock := Reindent(loopBlock, loop.Offset)
-			loopBlockTrimmed := strings.TrimRight(indentedLoopBlock, "\n\r")
-			loopBlockTrimmed = strings.TrimRight(loopBlockTrimmed, "\n")
-			loopBlockTrimmed = strings.TrimRight(loopBlockTrimmed, "\\s")
-			loopBlocks = append(loopBlocks, loopBlockTrimmed)
+	elements, ok := variable.([]interface{})
+	var keys []string
+	if entries, isMap := variable.(map[string]interface{}); isMap {
+		// the entries of a map are iterated as a list of their values, in the key order of the execution
+		keys = state.keys(entries)
+		elements = make([]interface{}, 0, len(keys))
+		for _, key := range keys {
+			elements = append(elements, entries[key])
 		}
+		ok = true
+	}
 
-		// loopRendered = strings.Join(loopBlocks, loop.Joiner)
-		loopRendered = strings.Join(loopBlocks, loop.Joiner+"\n")
-		loopTrimmed := strings.TrimRight(loopRendered, "\n\r")
-		loopTrimmed = strings.TrimRight(loopTrimmed, "\n")
-		loopTrimmed = strings.TrimRight(loopTrimmed, "\\s")
+	if (variable == nil || (ok && len(elements) == 0)) && n.hasElse {
+		return n.renderOtherwise(out, scope, state)
+	}
 
-		rendered = append(rendered, []byte(loopTrimmed)...)
+	if n.inline && !ok {
+		// the inline block syntax can be found in plain content such as f(x)[0]
+		out.WriteString(n.text)
+		return nil
+	}
 
-		// jump the cursor to the loop ending index character in the structure
-		cursor = loop.EndIndex
+	if variable != nil && !ok {
+		return &TypeMismatchError{
+			Location: Location{offset: n.position},
+			Message:  fmt.Sprintf("loop variable %q is not a list or a map ( found %T )", n.variable, variable),
+		}
 	}
 
-	for cursor < len(structure) {
-		rendered = append(rendered, structure[cursor])
-		cursor++
+	if len(elements) == 0 {
+		// nothing rendered : the line of the loop block is removed
+		return nil
 	}
 
-	return string(rendered)
+	out.WriteString(n.leading)
+
+	for idx, e := range elements {
+		if idx > 0 {
+			out.WriteString(n.separator())
+		}
+
+		metadata := loopMetadata(idx, idx == len(elements)-1, len(elements))
+		if keys != nil {
+			metadata[KeyVariable] = keys[idx]
+			metadata[ValueVariable] = e
+		}
+		if err := n.renderElement(out, scope, state, e, metadata); err != nil {
+			return err
+		}
+	}
+
+	out.WriteString(n.trailing)
+	return nil
 }
 
-// Produce the flat variables map corresponding to the flattified structure
-func FlattifyVariables(variables map[string]interface{}, loops []*Loop) map[string]interface{} {
-	var flatVariables = make(map[string]interface{})
+// Render the elements of an Iterable one at a time, the next element being read ahead to tell whether the current one is the last one
+func (n *loopNode) renderIterable(out *output, scope *Scope, state *renderState, iterable Iterable) error {
+	iterator, err := iterable.Iterate()
+	if err != nil {
+		return &IterationError{Location: Location{offset: n.position}, Variable: n.variable, Err: err}
+	}
+	defer iterator.Close()
 
-	for _, loop := range loops {
-		for idx, value := range loop.Values {
-			// TODO: handle the case in which loop.Values is a slice of primitive such as string, int, float, bool
-			for k, v := range value {
-				flatK := loop.Variable + "_" + k + "_" + fmt.Sprint(idx)
-				flatVariables[flatK] = v
-			}
+	element, ok, err := iterator.Next()
+	if err != nil {
+		return &IterationError{Location: Location{offset: n.position}, Variable: n.variable, Err: err}
+	}
+	if !ok {
+		return n.renderOtherwise(out, scope, state)
+	}
+
+	out.WriteString(n.leading)
+
+	for idx := 0; ok; idx++ {
+		next, nextOk, err := iterator.Next()
+		if err != nil {
+			return &IterationError{Location: Location{offset: n.position}, Variable: n.variable, Err: err}
+		}
+
+		if idx > 0 {
+			out.WriteString(n.separator())
+		}
+		if err := n.renderElement(out, scope, state, element, loopMetadata(idx, !nextOk, -1)); err != nil {
+			return err
 		}
+		if out.err != nil {
+			// the remaining elements are not read once the output cannot be written
+			return out.err
+		}
+
+		element, ok = next, nextOk
 	}
 
-	for k, v := range variables {
-		flatVariables[k] = v
+	out.WriteString(n.trailing)
+	return nil
+}
+
+// Render the else branch of a loop block whose variable is missing or empty, nothing being rendered without else branch
+func (n *loopNode) renderOtherwise(out *output, scope *Scope, state *renderState) error {
+	if !n.hasElse {
+		// nothing rendered : the line of the loop block is removed
+		return nil
 	}
 
-	return flatVariables
+	out.WriteString(n.leading)
+	if err := renderNodes(out, n.otherwise, scope, state); err != nil {
+		return err
+	}
+	out.WriteString(n.trailing)
+	return nil
 }
 
-// Rename the variable of a structure with a mapping of names
-func RenameVariables(
-	structure string,
-	mapping map[string]string,
-	leftDelimiter string,
-	rightDelimiter string,
-) string {
-	var rendered = structure
+// Separator written between the elements of a loop block
+func (n *loopNode) separator() string {
+	if n.inline {
+		return n.joiner
+	}
+
+	return n.joiner + "\n"
+}
 
-	for k, v := range mapping {
-		rendered = strings.ReplaceAll(
-			rendered,
-			leftDelimiter+k+rightDelimiter,
-			leftDelimiter+v+rightDelimiter,
-		)
+// Render the body of a loop block for an element : the block sees the element and its metadata on top of the enclosing variables
+func (n *loopNode) renderElement(out *output, scope *Scope, state *renderState, element interface{}, metadata map[string]interface{}) error {
+	elementScope := scope
+	if eCast, ok := element.(map[string]interface{}); ok {
+		elementScope = NewScope(eCast, elementScope)
 	}
+	metadata[CurrentElementVariable] = element
+	elementScope = NewScope(metadata, elementScope)
 
-	return rendered
+	return renderNodes(out, n.body, elementScope, state)
 }
 
-// Interpolate a structure with a map of variables
+func (n *conditionNode) render(out *output, scope *Scope, state *renderState) error {
+	for _, branch := range n.branches {
+		if branch.keyword != ElseKeyword {
+			value, err := branch.expression.Evaluate(scope)
+			if err != nil {
+				return moveError(err, branch.position)
+			}
+			if !IsTruthy(value) {
+				continue
+			}
+		}
+
+		out.WriteString(n.leading)
+		if err := renderNodes(out, branch.body, scope, state); err != nil {
+			return err
+		}
+		out.WriteString(n.trailing)
+		return nil
+	}
+
+	// nothing rendered : the line of the condition block is removed
+	return nil
+}
+
+// Render nodes to an output, stopping at the first write error
+func renderNodes(out *output, nodes []Node, scope *Scope, state *renderState) error {
+	for _, node := range nodes {
+		if err := node.render(out, scope, state); err != nil {
+			return err
+		}
+		if out.err != nil {
+			return out.err
+		}
+	}
+
+	return nil
+}
+
+// Interpolate the variable placeholders of a structure with a map of variables in a single pass, blocks being plain content.
+// The values are inserted literally : delimiters found inside them are never expanded, and escaped delimiters are plain content.
 func Interpolate(
 	structure string,
 	variables map[string]interface{},
@@ -248,52 +409,41 @@ func Interpolate(
 	rightDelimiter string,
 	panicIfNoMatch bool,
 ) (rendered string, replacements int, success bool) {
-	// initialize rendered content to input structure
-	rendered = structure
-	success = true
-	replacements = 0
-
-	for k, v := range variables {
-		repl := func(match string) string {
-			replacements++
-			return fmt.Sprintf("%v", v)
-		}
+	d := &delimiters{left: leftDelimiter, right: rightDelimiter}
+	nodes, err := parseTokens(lex(structure, d, false), nil)
+	if err != nil {
+		panic(locateError(err, "", structure))
+	}
 
-		regexpExpression := utils.RegexpEscapeString(leftDelimiter + k + rightDelimiter)
-		re := regexp.MustCompile(regexpExpression)
-		rendered = re.ReplaceAllStringFunc(rendered, repl)
-		// rendered = strings.ReplaceAll(rendered, leftDelimiter+k+rightDelimiter, fmt.Sprintf("%v", v))
-	}
-
-	// check if all the variables were successfully replaced ( if at least one occurence of the variable interpolation pattern is still in the rendered string the answer is no )
-	failedVariable := ""
-
-	variableWrapperRegexString := utils.GenerateWrapperRegexp(
-		leftDelimiter,
-		rightDelimiter,
-		"variable",
-		false,
-	)
-	variableRegexp := regexp.MustCompile(variableWrapperRegexString)
-	variableGroupNames := variableRegexp.SubexpNames()
-
-	for _, variableMatch := range variableRegexp.FindAllStringSubmatch(rendered, -1) {
-		for variableGroupIdx, variableGroupContent := range variableMatch {
-			name := variableGroupNames[variableGroupIdx]
-			if name == "variable" {
-				success = false
-				failedVariable = variableGroupContent
-			}
-		}
+	var b strings.Builder
+	state := &renderState{source: structure}
+	if err := renderOutput(&b, nodes, NewScope(variables, nil), state); err != nil {
+		panic(locateError(err, "", structure))
 	}
 
+	// check if all the variables were successfully replaced
+	success = len(state.missing) == 0
 	if panicIfNoMatch && !success {
-		panic(fmt.Sprintf("variable : %q not found in data", failedVariable))
+		panic(&MissingVariablesError{Missing: state.report().Missing})
 	}
 
-	return
+	return b.String(), state.replacements, success
 }
 
+// Compiled templates of Render and Execute
+var renderCache = NewCache(DefaultCacheSize)
+
+// Render a template against a map of variables, the errors being typed and located in the template
+func Execute(template string, variables map[string]interface{}, options Options) (string, error) {
+	compiled, err := renderCache.Compile(template, options)
+	if err != nil {
+		return "", err
+	}
+
+	return compiled.Execute(variables)
+}
+
+// Render a template against a map of variables, panicking on errors
 func Render(
 	template string,
 	variables map[string]interface{},
@@ -305,58 +455,18 @@ func Render(
 	rightLoopBlockDelimiter string,
 	panicIfNoMatch bool,
 ) string {
-	if len(leftDelimiter) == 0 {
-		leftDelimiter = "{{"
-	}
-	if len(rightDelimiter) == 0 {
-		rightDelimiter = "}}"
-	}
-	if len(leftLoopVariableDelimiter) == 0 {
-		leftDelimiter = "("
-	}
-	if len(rightLoopVariableDelimiter) == 0 {
-		rightDelimiter = ")"
-	}
-	if len(leftLoopBlockDelimiter) == 0 {
-		leftDelimiter = "["
-	}
-	if len(rightLoopBlockDelimiter) == 0 {
-		rightDelimiter = "]"
-	}
-
-	var loops []*Loop
-	var flatStructure string
-	var flatVariables map[string]interface{}
-	var rendered string
-
-	loops = ParseLoops(
-		template,
-		variables,
-		leftLoopVariableDelimiter,
-		rightLoopVariableDelimiter,
-		leftLoopBlockDelimiter,
-		rightLoopBlockDelimiter,
-	)
-
-	flatStructure = FlattifyStructure(
-		template,
-		loops,
-		leftDelimiter,
-		rightDelimiter,
-	)
-
-	flatVariables = FlattifyVariables(
-		variables,
-		loops,
-	)
-
-	rendered, _, _ = Interpolate(
-		flatStructure,
-		flatVariables,
-		leftDelimiter,
-		rightDelimiter,
-		panicIfNoMatch,
-	)
+	rendered, err := Execute(template, variables, Options{
+		LeftDelimiter:              leftDelimiter,
+		RightDelimiter:             rightDelimiter,
+		LeftLoopVariableDelimiter:  leftLoopVariableDelimiter,
+		RightLoopVariableDelimiter: rightLoopVariableDelimiter,
+		LeftLoopBlockDelimiter:     leftLoopBlockDelimiter,
+		RightLoopBlockDelimiter:    rightLoopBlockDelimiter,
+		PanicIfNoMatch:             panicIfNoMatch,
+	})
+	if err != nil {
+		panic(err)
+	}
 
 	return rendered
 }
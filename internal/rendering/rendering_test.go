@@ -0,0 +1,1920 @@
+package rendering
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type testRender struct {
+	template  string
+	variables string
+}
+
+func runRenderTests(t *testing.T, tests []struct {
+	args testRender
+	want string
+}) {
+	for i, tc := range tests {
+		var variables map[string]interface{}
+		if err := json.Unmarshal([]byte(tc.args.variables), &variables); err != nil {
+			t.Fatalf("test #%d invalid variables : %v", i+1, err)
+		}
+
+		out := Render(tc.args.template, variables, "{{", "}}", "(", ")", "[", "]", false)
+		if out != tc.want {
+			t.Errorf("test #%d failed expected result \n want : %s \n have : %s", i+1, tc.want, out)
+		}
+	}
+}
+
+func TestRenderNestedLoops(t *testing.T) {
+	tests := []struct {
+		args testRender
+		want string
+	}{
+		{
+			args: testRender{
+				template: "orders:\n" +
+					"  (orders)[\n" +
+					"  - {{name}} for {{customer}}\n" +
+					"    (lines)[\n" +
+					"    * {{name}} x{{qty}} ({{customer}}, {{currency}})\n" +
+					"    ]\n" +
+					"  ]\n" +
+					"end",
+				variables: `{
+					"currency": "EUR",
+					"orders": [
+						{"name": "o1", "customer": "alice", "lines": [{"name": "l1", "qty": 1}, {"name": "l2", "qty": 2}]},
+						{"name": "o2", "customer": "bob", "lines": [{"name": "l3", "qty": 3}]}
+					]
+				}`,
+			},
+			want: "orders:\n" +
+				"  - o1 for alice\n" +
+				"    * l1 x1 (alice, EUR)\n" +
+				"    * l2 x2 (alice, EUR)\n" +
+				"  - o2 for bob\n" +
+				"    * l3 x3 (bob, EUR)\n" +
+				"end",
+		},
+		{
+			args: testRender{
+				template: "(groups)[\n" +
+					"{{group}}:\n" +
+					"  (teams)[\n" +
+					"  {{team}}:\n" +
+					"    (members)(,)[\n" +
+					"    {{member}}@{{team}}.{{group}}\n" +
+					"    ]\n" +
+					"  ]\n" +
+					"]",
+				variables: `{
+					"groups": [
+						{"group": "g1", "teams": [
+							{"team": "t1", "members": [{"member": "m1"}, {"member": "m2"}]},
+							{"team": "t2", "members": [{"member": "m3"}]}
+						]}
+					]
+				}`,
+			},
+			want: "g1:\n" +
+				"  t1:\n" +
+				"    m1@t1.g1,\n" +
+				"    m2@t1.g1\n" +
+				"  t2:\n" +
+				"    m3@t2.g1",
+		},
+		{
+			args: testRender{
+				template: "(orders)[\n" +
+					"{{name}}\n" +
+					"  (tags)[\n" +
+					"  {{name}}-{{tag}}\n" +
+					"  ]\n" +
+					"]",
+				variables: `{
+					"tags": [{"tag": "a"}, {"tag": "b"}],
+					"orders": [{"name": "o1"}]
+				}`,
+			},
+			want: "o1\n" +
+				"  o1-a\n" +
+				"  o1-b",
+		},
+	}
+
+	runRenderTests(t, tests)
+}
+
+func TestRenderPrimitiveLoops(t *testing.T) {
+	tests := []struct {
+		args testRender
+		want string
+	}{
+		{
+			args: testRender{
+				template: "tags:\n" +
+					"  (tags)[\n" +
+					"  - {{.}}\n" +
+					"  ]",
+				variables: `{"tags": ["a", "b", 3, true]}`,
+			},
+			want: "tags:\n" +
+				"  - a\n" +
+				"  - b\n" +
+				"  - 3\n" +
+				"  - true",
+		},
+		{
+			args: testRender{
+				template: "(items)[\n" +
+					"{{.}}/{{name}}\n" +
+					"]",
+				variables: `{"items": ["plain", {"name": "named"}]}`,
+			},
+			want: "plain/{{name}}\n" +
+				"map[name:named]/named",
+		},
+		{
+			args: testRender{
+				template: "(matrix)[\n" +
+					"row:\n" +
+					"  (.)(,)[\n" +
+					"  {{.}}\n" +
+					"  ]\n" +
+					"]",
+				variables: `{"matrix": [[1, 2], [3]]}`,
+			},
+			want: "row:\n" +
+				"  1,\n" +
+				"  2\n" +
+				"row:\n" +
+				"  3",
+		},
+	}
+
+	runRenderTests(t, tests)
+}
+
+func TestParseLoopsNotAList(t *testing.T) {
+	var variables map[string]interface{}
+	json.Unmarshal([]byte(`{"user": "alice"}`), &variables)
+
+	compiled, err := Compile("(user)[\n{{name}}\n]", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = compiled.Execute(variables)
+	var typeMismatch *TypeMismatchError
+	if !errors.As(err, &typeMismatch) || typeMismatch.Message != `loop variable "user" is not a list or a map ( found string )` {
+		t.Errorf("expected a not a list error, have : %v", err)
+	}
+}
+
+func TestRenderPaths(t *testing.T) {
+	tests := []struct {
+		args testRender
+		want string
+	}{
+		{
+			args: testRender{
+				template:  "{{customer.name}} lives in {{customer.address.city}} ( first item {{items[0].name}}, last tag {{items[1].tags[1]}} )",
+				variables: `{"customer": {"name": "alice", "address": {"city": "Paris"}}, "items": [{"name": "i1"}, {"name": "i2", "tags": ["a", "b"]}]}`,
+			},
+			want: "alice lives in Paris ( first item i1, last tag b )",
+		},
+		{
+			args: testRender{
+				template:  "{{ customer.name }} {{customer.phone}} {{items[5].name}} {{a.b}}",
+				variables: `{"customer": {"name": "alice"}, "items": [], "a.b": "dotted key"}`,
+			},
+			want: "alice {{customer.phone}} {{items[5].name}} dotted key",
+		},
+		{
+			args: testRender{
+				template: "(customer.orders)[\n" +
+					"{{id}} to {{address.city}} ( {{lines[0].sku}} ) for {{customer.name}}\n" +
+					"  (lines)[\n" +
+					"  {{.sku}}\n" +
+					"  ]\n" +
+					"]",
+				variables: `{"customer": {"name": "alice", "orders": [
+					{"id": 1, "address": {"city": "Paris"}, "lines": [{"sku": "s1"}, {"sku": "s2"}]}
+				]}}`,
+			},
+			want: "1 to Paris ( s1 ) for alice\n" +
+				"  s1\n" +
+				"  s2",
+		},
+	}
+
+	runRenderTests(t, tests)
+}
+
+func TestSplitPath(t *testing.T) {
+	tests := []struct {
+		args string
+		want []interface{}
+	}{
+		{args: "name", want: []interface{}{"name"}},
+		{args: "customer.address.city", want: []interface{}{"customer", "address", "city"}},
+		{args: "items[0].name", want: []interface{}{"items", 0, "name"}},
+		{args: "matrix[1][2]", want: []interface{}{"matrix", 1, 2}},
+		{args: "labels[\"app.kubernetes.io/name\"]", want: []interface{}{"labels", "app.kubernetes.io/name"}},
+		{args: ".", want: []interface{}{"."}},
+		{args: ".name", want: []interface{}{".", "name"}},
+		{args: ".[0]", want: []interface{}{".", 0}},
+	}
+
+	for i, tc := range tests {
+		out := SplitPath(tc.args)
+		if fmt.Sprint(out) != fmt.Sprint(tc.want) {
+			t.Errorf("test #%d failed expected result \n want : %#v \n have : %#v", i+1, tc.want, out)
+		}
+	}
+}
+
+func TestRenderConditions(t *testing.T) {
+	tests := []struct {
+		args testRender
+		want string
+	}{
+		{
+			args: testRender{
+				template: "start\n" +
+					"(if region == \"eu\")[\n" +
+					"europe\n" +
+					"](elif region == \"us\")[\n" +
+					"america\n" +
+					"](else)[\n" +
+					"elsewhere\n" +
+					"]\n" +
+					"end",
+				variables: `{"region": "us"}`,
+			},
+			want: "start\n" +
+				"america\n" +
+				"end",
+		},
+		{
+			args: testRender{
+				template: "start\n" +
+					"  (if debug)[\n" +
+					"  debug: true\n" +
+					"  ]\n" +
+					"  (else)[\n" +
+					"  debug: false\n" +
+					"  ]\n" +
+					"  (if missing)[\n" +
+					"  never\n" +
+					"  ]\n" +
+					"end",
+				variables: `{"debug": false}`,
+			},
+			want: "start\n" +
+				"  debug: false\n" +
+				"end",
+		},
+		{
+			args: testRender{
+				template: "(items)(,)[\n" +
+					"{{name}}\n" +
+					"  (if qty > 10 and not discontinued)[\n" +
+					"  bulk {{qty}} {{currency}}\n" +
+					"    (if qty >= 100 or name == currency)[\n" +
+					"    huge\n" +
+					"    ]\n" +
+					"  ]\n" +
+					"]",
+				variables: `{"currency": "EUR", "items": [
+					{"name": "a", "qty": "12"},
+					{"name": "b", "qty": "120"},
+					{"name": "c", "qty": "120", "discontinued": true},
+					{"name": "d", "qty": 1}
+				]}`,
+			},
+			want: "a\n" +
+				"  bulk 12 EUR,\n" +
+				"b\n" +
+				"  bulk 120 EUR\n" +
+				"    huge,\n" +
+				"c,\n" +
+				"d",
+		},
+		{
+			args: testRender{
+				template: "(if customer.vip)[\n" +
+					"(customer.orders)[\n" +
+					"{{id}}\n" +
+					"]\n" +
+					"]",
+				variables: `{"customer": {"vip": true, "orders": [{"id": 1}, {"id": 2}]}}`,
+			},
+			want: "1\n" +
+				"2",
+		},
+		{
+			args: testRender{
+				template: "(items)[\n" +
+					"(if (a or b) and c)[\n" +
+					"{{name}} matches\n" +
+					"](elif (name == \")\"))[\n" +
+					"{{name}} is a parenthesis\n" +
+					"](else)[\n" +
+					"{{name}} does not match\n" +
+					"]\n" +
+					"]",
+				variables: `{"items": [{"name": "x", "b": true, "c": true}, {"name": "y", "a": true}, {"name": ")"}]}`,
+			},
+			want: "x matches\n" +
+				"y does not match\n" +
+				") is a parenthesis",
+		},
+	}
+
+	runRenderTests(t, tests)
+}
+
+func TestParseConditionsErrors(t *testing.T) {
+	tests := []struct {
+		args string
+		want string
+	}{
+		{
+			args: "(else)[\nnever\n]",
+			want: "1:1: \"else\" branch without a preceding \"if\" branch\n" +
+				" 1 | (else)[\n" +
+				"   | ^",
+		},
+		{
+			args: "  (if)[\n  never\n  ]",
+			want: "1:3: \"if\" branch without a condition\n" +
+				" 1 |   (if)[\n" +
+				"   |   ^",
+		},
+		{
+			args: "(if a)[\na\n](else)[\nb\n](elif c)[\nc\n]",
+			want: "5:2: \"elif\" branch following an \"else\" branch\n" +
+				" 5 | ](elif c)[\n" +
+				"   |  ^",
+		},
+		{
+			args: "(raw x)[\n{{x}}\n]",
+			want: "1:1: \"raw\" block does not take an expression\n" +
+				" 1 | (raw x)[\n" +
+				"   | ^",
+		},
+		{
+			args: "a\n(raw)[\n(x)[\n{{x}}",
+			want: "2:1: block \"raw\" is not closed\n" +
+				" 2 | (raw)[\n" +
+				"   | ^",
+		},
+	}
+
+	for i, tc := range tests {
+		_, err := Compile(tc.args, Options{})
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("test #%d failed expected result \n want : %s \n have : %v", i+1, tc.want, err)
+		}
+	}
+}
+
+func TestEvaluateExpression(t *testing.T) {
+	var variables map[string]interface{}
+	json.Unmarshal([]byte(`{"n": 3, "s": "abc", "csv": "12", "list": [], "user": {"name": "alice", "admin": true}}`), &variables)
+
+	tests := []struct {
+		args string
+		want interface{}
+	}{
+		{args: "n", want: float64(3)},
+		{args: "n == 3", want: true},
+		{args: "n != 3.0", want: false},
+		{args: "csv > 9", want: true},
+		{args: "csv > \"9\"", want: false},
+		{args: "s < 'abd'", want: true},
+		{args: "user.admin && user.name == \"alice\"", want: true},
+		{args: "not list", want: true},
+		{args: "missing or n >= 3", want: true},
+		{args: "!(n > 1 and s == \"x\") || false", want: true},
+		{args: "missing == null", want: true},
+		{args: "n * 2 + 1", want: float64(7)},
+		{args: "(n + 1) * -2", want: float64(-8)},
+		{args: "csv / n - 10 % 4", want: float64(2)},
+		{args: "s + \"-\" + n", want: "abc-3"},
+		{args: "n + 1 > csv", want: false},
+		{args: "missing * 2", want: nil},
+	}
+
+	for i, tc := range tests {
+		out, err := EvaluateExpression(tc.args, variables)
+		if err != nil || out != tc.want {
+			t.Errorf("test #%d failed expected result \n want : %#v \n have : %#v ( %v )", i+1, tc.want, out, err)
+		}
+	}
+
+	errorTests := []struct {
+		args string
+		want string
+	}{
+		{args: "n > ", want: `unexpected end of expression at position 4 of expression "n > "`},
+		{args: "(n > 1", want: `missing closing parenthesis at position 6 of expression "(n > 1"`},
+		{args: "s == \"abc", want: `unterminated string at position 5 of expression "s == \"abc"`},
+		{args: "user > 1", want: `cannot compare map[string]interface {} and float64 with ">" at position 5 of expression "user > 1"`},
+		{args: "s * n", want: `cannot apply "*" to string and float64 at position 2 of expression "s * n"`},
+		{args: "n / (csv - 12)", want: `division by zero at position 2 of expression "n / (csv - 12)"`},
+		{args: "-user.admin", want: `cannot negate bool at position 0 of expression "-user.admin"`},
+	}
+
+	for i, tc := range errorTests {
+		_, err := EvaluateExpression(tc.args, variables)
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("error test #%d failed expected result \n want : %s \n have : %v", i+1, tc.want, err)
+		}
+	}
+}
+
+func TestRenderArithmetic(t *testing.T) {
+	tests := []struct {
+		args testRender
+		want string
+	}{
+		{
+			args: testRender{
+				template: "(items)[\n" +
+					"{{first + \" \" + last}} : {{price * quantity}} ( {{quantity > 10}} )\n" +
+					"]",
+				variables: `{"items": [{"first": "a", "last": "b", "price": "2.5", "quantity": 4}, {"first": "c", "last": "d", "price": 1, "quantity": "12"}]}`,
+			},
+			want: "a b : 10 ( false )\n" +
+				"c d : 12 ( true )",
+		},
+		{
+			args: testRender{
+				template:  "{{ price * quantity }} {{ price * quantity ?? 0 }} {{ @index + 1 }}",
+				variables: `{"price": 2}`,
+			},
+			want: "{{ price * quantity }} 0 {{ @index + 1 }}",
+		},
+		{
+			args: testRender{
+				template:  "{{price * 1 + tax}} {{price - tax}} {{price * quantity}} {{price + tax}} {{\"1\" + \"2\"}} {{id + 1}}",
+				variables: `{"price": "1000", "tax": "5", "quantity": "2", "id": "12"}`,
+			},
+			want: "1005 995 2000 10005 12 13",
+		},
+		{
+			args: testRender{
+				template: "(versions)[\n" +
+					"{{major + \".\" + minor}} {{first + \" \" + last}}\n" +
+					"]",
+				variables: `{"versions": [{"major": "1", "minor": "2", "first": "Nan", "last": "Inf"}, {"major": 1, "minor": 2, "first": "1.", "last": "0x1p3"}]}`,
+			},
+			want: "1.2 Nan Inf\n" +
+				"1.2 1. 0x1p3",
+		},
+	}
+
+	runRenderTests(t, tests)
+
+	_, err := Execute("a\n  {{ name * 2 }}", map[string]interface{}{"name": "x"}, Options{Name: "t"})
+	var typeMismatch *TypeMismatchError
+	if !errors.As(err, &typeMismatch) || typeMismatch.Line != 2 || typeMismatch.Column != 11 {
+		t.Errorf("expected a located type mismatch error, have : %v", err)
+	}
+}
+
+func TestRenderFilters(t *testing.T) {
+	tests := []struct {
+		args testRender
+		want string
+	}{
+		{
+			args: testRender{
+				template:  "{{ name | trim | upper }} {{name|truncate:3,\"...\"|lower}} {{ city | title }} {{ word | capitalize }}",
+				variables: `{"name": "  Alice Smith ", "city": "new york", "word": "élan"}`,
+			},
+			want: "ALICE SMITH   a... New York Élan",
+		},
+		{
+			args: testRender{
+				template:  "[{{ id | padleft: 5, \"0\" }}] [{{ code | pad: 6 }}] {{ path | replace: \"/\", \"::\" }} {{ region | default: \"eu-west-1\" }} {{ empty | default: zone }}",
+				variables: `{"id": 42, "code": "ab", "path": "a/b/c", "empty": "", "zone": "z1"}`,
+			},
+			want: "[00042] [ab    ] a::b::c eu-west-1 z1",
+		},
+		{
+			args: testRender{
+				template:  "{{ tags | join }} / {{ tags | join: \"|\" }} / {{ tags | length }} / {{ user | json }} / {{ user.name | json }}",
+				variables: `{"tags": ["a", "b"], "user": {"name": "alice", "age": 30}}`,
+			},
+			want: "a, b / a|b / 2 / {\"age\":30,\"name\":\"alice\"} / \"alice\"",
+		},
+		{
+			args: testRender{
+				template:  "{{ user | yaml }}",
+				variables: `{"user": {"name": "alice", "tags": ["a"]}}`,
+			},
+			want: "name: alice\ntags:\n    - a",
+		},
+		{
+			args: testRender{
+				template: "(users)(,)[\n" +
+					"{{ name | upper }}={{ . | json }}:{{ upper | default: \"-\" }}\n" +
+					"]",
+				variables: `{"users": [{"name": "a", "upper": "yes"}, {"name": "b"}]}`,
+			},
+			want: "A={\"name\":\"a\",\"upper\":\"yes\"}:yes,\n" +
+				"B={\"name\":\"b\"}:-",
+		},
+		{
+			args: testRender{
+				template:  "{{ missing | upper }} {{ not an expression }}",
+				variables: `{}`,
+			},
+			want: "{{ missing | upper }} {{ not an expression }}",
+		},
+	}
+
+	runRenderTests(t, tests)
+}
+
+func TestRenderFormatting(t *testing.T) {
+	tests := []struct {
+		args testRender
+		want string
+	}{
+		{
+			args: testRender{
+				template:  "{{ big }} {{ big | number: 2, \",\" }} {{ small | number: 2 }} {{ neg | number: 1, \" \", \",\" }} {{ csv | number }}",
+				variables: `{"big": 1000000, "small": 0.125, "neg": -1234.56, "csv": "12.7"}`,
+			},
+			want: "1000000 1,000,000.00 0.13 -1 234,6 13",
+		},
+		{
+			args: testRender{
+				template:  "{{ ratio | percent }} {{ ratio | percent: 1 }} {{ price | currency: \"$\" }} {{ loss | currency: \"$\", 0 }} {{ price | currency: \" €\", 2, \" \", \",\" }}",
+				variables: `{"ratio": 0.256, "price": 1234.5, "loss": -1500}`,
+			},
+			want: "26% 25.6% $1,234.50 -$1,500 1 234,50 €",
+		},
+		{
+			args: testRender{
+				template:  "{{ iso | date: \"%d/%m/%Y\" }} {{ stamp | date: \"%b %e, %Y %H:%M\" }} {{ us | date: \"%Y-%m-%d\", \"%m-%d-%y\" }} {{ iso | date }}",
+				variables: `{"iso": "2024-03-09", "stamp": "2024-03-09T14:05:00Z", "us": "03-09-24"}`,
+			},
+			want: "09/03/2024 Mar 9, 2024 14:05 2024-03-09 2024-03-09",
+		},
+		{
+			args: testRender{
+				template:  "{{ iso | date: \"Q1 %Y\" }} / {{ iso | date: \"%A, Monday edition\" }} / {{ stamp | date: \"%Y-%m-%d 15h %H:%M 100%%\" }} / {{ week | date: \"%d/%m/%Y\", \"Week 1 of %Y-%m-%d\" }}",
+				variables: `{"iso": "2024-03-09", "stamp": "2024-03-09T14:05:00Z", "week": "Week 1 of 2024-01-05"}`,
+			},
+			want: "Q1 2024 / Saturday, Monday edition / 2024-03-09 15h 14:05 100% / 05/01/2024",
+		},
+	}
+
+	runRenderTests(t, tests)
+
+	variables := map[string]interface{}{"0": "report", "day": "2024-03-09", "total": 1500}
+	if have, _, _ := Interpolate("{0}_{day | date: \"%Y%m%d\"}_{total | number: 0, \"_\"}", variables, "{", "}", false); have != "report_20240309_1_500" {
+		t.Errorf("unexpected formatted file name : %s", have)
+	}
+
+	errorTests := []struct {
+		args string
+		want string
+	}{
+		{args: "name | number", want: `filter "number" : expects a number, "alice" given at position 7 of expression "name | number"`},
+		{args: "name | date", want: `filter "date" : unknown date layout of "alice" at position 7 of expression "name | date"`},
+		{args: "day | date: \"%d\", \"Day %d/%m/%Y\"", want: `filter "date" : date "2024-03-09" does not match layout "Day %d/%m/%Y" at position 6 of expression "day | date: \"%d\", \"Day %d/%m/%Y\""`},
+		{args: "day | date: \"%Q\"", want: `filter "date" : unknown directive %Q in date layout "%Q" at position 6 of expression "day | date: \"%Q\""`},
+	}
+
+	for i, tc := range errorTests {
+		_, _, err := EvaluatePlaceholder(tc.args, map[string]interface{}{"name": "alice", "day": "2024-03-09"})
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("error test #%d failed expected result \n want : %s \n have : %v", i+1, tc.want, err)
+		}
+	}
+}
+
+func TestEvaluatePlaceholderErrors(t *testing.T) {
+	variables := map[string]interface{}{"name": "alice", "tags": "a"}
+
+	tests := []struct {
+		args string
+		want string
+	}{
+		{args: "name | shout", want: `unknown filter "shout" at position 7 of expression "name | shout"`},
+		{args: "name | truncate", want: `filter "truncate" : expects 1 to 2 argument(s), 0 given at position 7 of expression "name | truncate"`},
+		{args: "name | truncate: \"x\"", want: `filter "truncate" : argument 1 must be an integer, "x" given at position 7 of expression "name | truncate: \"x\""`},
+		{args: "name | truncate: -3", want: `filter "truncate" : argument 1 must not be negative, -3 given at position 7 of expression "name | truncate: -3"`},
+		{args: "name | pad: -1", want: `filter "pad" : argument 1 must not be negative, -1 given at position 7 of expression "name | pad: -1"`},
+		{args: "name | padleft: 100000000000, \"0\"", want: `filter "padleft" : argument 1 must not exceed 65536, 100000000000 given at position 7 of expression "name | padleft: 100000000000, \"0\""`},
+		{args: "tags | join", want: `filter "join" : expects a list, string given at position 7 of expression "tags | join"`},
+	}
+
+	for i, tc := range tests {
+		_, _, err := EvaluatePlaceholder(tc.args, variables)
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("test #%d failed expected result \n want : %s \n have : %v", i+1, tc.want, err)
+		}
+	}
+}
+
+func TestRenderFallbacks(t *testing.T) {
+	tests := []struct {
+		args testRender
+		want string
+	}{
+		{
+			args: testRender{
+				template:  `{{region ?? "eu-west-1"}} {{ zone ?? region ?? "none" }} {{ name ?? "anonymous" | upper }} {{ port ?? 8080 }}`,
+				variables: `{"name": "alice", "zone": null}`,
+			},
+			want: "eu-west-1 none ALICE 8080",
+		},
+		{
+			args: testRender{
+				template:  `{{ region ?? "eu-west-1" }} {{ user.city ?? user.country }} {{ tag! }} {{ user.country! }} {{ missing ?? other }}`,
+				variables: `{"region": "us-east-1", "tag": "v1", "user": {"country": "fr"}}`,
+			},
+			want: "us-east-1 fr v1 fr {{ missing ?? other }}",
+		},
+		{
+			args: testRender{
+				template: "(users)(,)[\n" +
+					"{{ name }}:{{ role ?? \"guest\" }}:{{ name! }}\n" +
+					"]",
+				variables: `{"users": [{"name": "a", "role": "admin"}, {"name": "b"}]}`,
+			},
+			want: "a:admin:a,\n" +
+				"b:guest:b",
+		},
+	}
+
+	runRenderTests(t, tests)
+}
+
+func TestRenderRequired(t *testing.T) {
+	tests := []struct {
+		args testRender
+		want string
+	}{
+		{args: testRender{template: "{{ region! }}", variables: `{}`}, want: "1:4: variable : \"region\" not found in data\n 1 | {{ region! }}\n   |    ^"},
+		{args: testRender{template: "{{ user.city | upper! }}", variables: `{"user": {}}`}, want: "1:4: variable : \"user.city\" not found in data\n 1 | {{ user.city | upper! }}\n   |    ^"},
+		{args: testRender{template: "(users)[\n{{ role! }}\n]", variables: `{"users": [{"role": "a"}, {}]}`}, want: "2:4: variable : \"role\" not found in data\n 2 | {{ role! }}\n   |    ^"},
+	}
+
+	for i, tc := range tests {
+		var variables map[string]interface{}
+		if err := json.Unmarshal([]byte(tc.args.variables), &variables); err != nil {
+			t.Fatal(err)
+		}
+
+		func() {
+			defer func() {
+				err, _ := recover().(error)
+				if err == nil || err.Error() != tc.want {
+					t.Errorf("test #%d failed expected result \n want : %s \n have : %v", i+1, tc.want, err)
+				}
+			}()
+			Render(tc.args.template, variables, "", "", "", "", "", "", false)
+		}()
+	}
+}
+
+func TestRenderLoopMetadata(t *testing.T) {
+	tests := []struct {
+		args testRender
+		want string
+	}{
+		{
+			args: testRender{
+				template: "[\n" +
+					"  (users)[\n" +
+					"  (if @last)[\n" +
+					"  \"{{ name }}\"\n" +
+					"  ](else)[\n" +
+					"  \"{{ name }}\",\n" +
+					"  ]\n" +
+					"  ]\n" +
+					"]",
+				variables: `{"users": [{"name": "a"}, {"name": "b"}, {"name": "c"}]}`,
+			},
+			want: "[\n" +
+				"  \"a\",\n" +
+				"  \"b\",\n" +
+				"  \"c\"\n" +
+				"]",
+		},
+		{
+			args: testRender{
+				template: "(items)[\n" +
+					"{{ @number }}/{{ @length }} {{ . }} index={{ @index }} first={{ @first }} even={{ @even }} odd={{ @odd }}\n" +
+					"]",
+				variables: `{"items": ["x", "y"]}`,
+			},
+			want: "1/2 x index=0 first=true even=true odd=false\n" +
+				"2/2 y index=1 first=false even=false odd=true",
+		},
+		{
+			args: testRender{
+				template: "(rows)[\n" +
+					"(if @even)[\n" +
+					"<tr class=\"light\">{{ @number }}</tr>\n" +
+					"](else)[\n" +
+					"<tr class=\"dark\">{{ @number }}</tr>\n" +
+					"]\n" +
+					"]",
+				variables: `{"rows": [1, 2, 3]}`,
+			},
+			want: "<tr class=\"light\">1</tr>\n" +
+				"<tr class=\"dark\">2</tr>\n" +
+				"<tr class=\"light\">3</tr>",
+		},
+		{
+			args: testRender{
+				template: "(groups)[\n" +
+					"group {{ @index }}\n" +
+					"(items)(,)[\n" +
+					"{{ . }}{{ @index }}{{ @last | json }}\n" +
+					"]\n" +
+					"]",
+				variables: `{"groups": [{"items": ["a", "b"]}, {"items": ["c"]}]}`,
+			},
+			want: "group 0\n" +
+				"a0false,\n" +
+				"b1true\n" +
+				"group 1\n" +
+				"c0true",
+		},
+	}
+
+	runRenderTests(t, tests)
+}
+
+func TestRenderValuesLiterally(t *testing.T) {
+	tests := []struct {
+		args testRender
+		want string
+	}{
+		{
+			args: testRender{
+				template:  "{{a}} {{b}}",
+				variables: `{"a": "{{b}}", "b": "{{a}}"}`,
+			},
+			want: "{{b}} {{a}}",
+		},
+		{
+			args: testRender{
+				template:  "{{cell}}",
+				variables: `{"cell": "(items)[\n{{.}}\n]", "items": ["x"]}`,
+			},
+			want: "(items)[\n{{.}}\n]",
+		},
+		{
+			args: testRender{
+				template: "(rows)[\n" +
+					"{{name}}={{value}}\n" +
+					"]",
+				variables: `{"secret": "s3cr3t", "rows": [{"name": "{{secret}}", "value": "{{ secret | upper }}"}]}`,
+			},
+			want: "{{secret}}={{ secret | upper }}",
+		},
+	}
+
+	runRenderTests(t, tests)
+}
+
+func TestInterpolateValuesLiterally(t *testing.T) {
+	variables := map[string]interface{}{}
+	for i := 0; i < 20; i++ {
+		variables[fmt.Sprintf("k%d", i)] = fmt.Sprintf("{k%d}", (i+1)%20)
+	}
+
+	want, _, _ := Interpolate("{k0}-{k5}-{k19}", variables, "{", "}", false)
+	if want != "{k1}-{k6}-{k0}" {
+		t.Fatalf("values were expanded : %s", want)
+	}
+
+	// the output must not depend on the iteration order of the variables
+	for i := 0; i < 50; i++ {
+		if have, _, _ := Interpolate("{k0}-{k5}-{k19}", variables, "{", "}", false); have != want {
+			t.Fatalf("run #%d expected result \n want : %s \n have : %s", i+1, want, have)
+		}
+	}
+}
+
+func TestRenderEscapes(t *testing.T) {
+	tests := []struct {
+		args testRender
+		want string
+	}{
+		{
+			args: testRender{
+				template:  `\{{name}} is {{name}}`,
+				variables: `{"name": "x"}`,
+			},
+			want: "{{name}} is x",
+		},
+		{
+			args: testRender{
+				template:  `{{ name ?? "\}}" }}`,
+				variables: `{}`,
+			},
+			want: "}}",
+		},
+		{
+			args: testRender{
+				template:  `\\{{name}}`,
+				variables: `{"name": "x"}`,
+			},
+			want: `\x`,
+		},
+		{
+			args: testRender{
+				template: `\(items)[` + "\n" +
+					"{{.}}\n" +
+					`\]`,
+				variables: `{"items": [1, 2]}`,
+			},
+			want: "(items)[\n" +
+				"{{.}}\n" +
+				"]",
+		},
+		{
+			args: testRender{
+				template: "(items)[\n" +
+					`  - \[{{.}}\]` + "\n" +
+					"  \\]\n" +
+					"]",
+				variables: `{"items": [1, 2]}`,
+			},
+			want: "- \\[1\\]\n" +
+				"]\n" +
+				"- \\[2\\]\n" +
+				"]",
+		},
+		{
+			args: testRender{
+				template:  `items: (items)[{{.}}\]]`,
+				variables: `{"items": [1, 2]}`,
+			},
+			want: "items: 1]2]",
+		},
+		{
+			args: testRender{
+				template:  `echo \(a\) \d && find . \( -name x \) && grep '\[0-9\]' \}}`,
+				variables: `{}`,
+			},
+			want: `echo \(a\) \d && find . \( -name x \) && grep '\[0-9\]' \}}`,
+		},
+		{
+			args: testRender{
+				template:  `C:\\{{dir}} C:\temp \\d`,
+				variables: `{"dir": "x"}`,
+			},
+			want: `C:\x C:\temp \\d`,
+		},
+	}
+
+	runRenderTests(t, tests)
+}
+
+func TestRenderRawBlocks(t *testing.T) {
+	tests := []struct {
+		args testRender
+		want string
+	}{
+		{
+			args: testRender{
+				template: "name: {{name}}\n" +
+					"(raw)[\n" +
+					"value: {{ .Values.name }}\n" +
+					"(items)[\n" +
+					`\{{.}}` + "\n" +
+					"]\n" +
+					"end",
+				variables: `{"name": "chart", "items": [1]}`,
+			},
+			want: "name: chart\n" +
+				"value: {{ .Values.name }}\n" +
+				"(items)[\n" +
+				`\{{.}}` + "\n" +
+				"end",
+		},
+		{
+			args: testRender{
+				template: "(items)[\n" +
+					"  (raw)[\n" +
+					"    {% for i in {{.}} %}\n" +
+					"  ]\n" +
+					"  {{.}}\n" +
+					"]",
+				variables: `{"items": [1, 2]}`,
+			},
+			want: "{% for i in {{.}} %}\n" +
+				"1\n" +
+				"{% for i in {{.}} %}\n" +
+				"2",
+		},
+	}
+
+	runRenderTests(t, tests)
+}
+
+func TestExecuteEscaping(t *testing.T) {
+	variables := map[string]interface{}{
+		"value": `<a href="x">O'Neil & co</a>` + "\n",
+		"word":  "file-1.txt",
+		"tags":  []interface{}{"a", `b"c`},
+	}
+
+	tests := []struct {
+		escaping string
+		template string
+		want     string
+	}{
+		{NoEscaping, "{{value}}", `<a href="x">O'Neil & co</a>` + "\n"},
+		{HTMLEscaping, "<p>{{value}}</p>", "<p>&lt;a href=&#34;x&#34;&gt;O&#39;Neil &amp; co&lt;/a&gt;\n</p>"},
+		{XMLEscaping, "<v>{{value}}</v>", "<v>&lt;a href=&quot;x&quot;&gt;O&apos;Neil &amp; co&lt;/a&gt;\n</v>"},
+		{JSONEscaping, `{"v": "{{value}}"}`, `{"v": "<a href=\"x\">O'Neil & co</a>\n"}`},
+		{YAMLEscaping, `v: "{{value}}"`, `v: "<a href=\"x\">O'Neil & co</a>\n"`},
+		{ShellEscaping, "cat {{word}} {{value}}", "cat file-1.txt '<a href=\"x\">O'\"'\"'Neil & co</a>\n'"},
+		{SQLEscaping, "SELECT '{{value}}'", "SELECT '<a href=\"x\">O''Neil & co</a>\n'"},
+		{HTMLEscaping, "{{ value | raw }}", `<a href="x">O'Neil & co</a>` + "\n"},
+		{JSONEscaping, "{{ word | upper }}", "FILE-1.TXT"},
+		{JSONEscaping, `{"tags": {{ tags | json }}, "v": "{{ value | upper }}"}`, `{"tags": ["a","b\"c"], "v": "<A HREF=\"X\">O'NEIL & CO</A>\n"}`},
+		{YAMLEscaping, "tags: {{ tags | json }}", `tags: ["a","b\"c"]`},
+		{ShellEscaping, "{{ tags | json | length }}", "12"},
+	}
+
+	for i, tc := range tests {
+		compiled, err := Compile(tc.template, Options{Escaping: tc.escaping})
+		if err != nil {
+			t.Fatalf("test #%d unexpected error : %v", i+1, err)
+		}
+		have, err := compiled.Execute(variables)
+		if err != nil || have != tc.want {
+			t.Errorf("test #%d failed expected result \n want : %s \n have : %s ( %v )", i+1, tc.want, have, err)
+		}
+	}
+
+	var invalid *InvalidOptionError
+	if _, err := Compile("{{value}}", Options{Escaping: "csv"}); !errors.As(err, &invalid) {
+		t.Errorf("expected an invalid option error, have : %v", err)
+	}
+
+	for path, want := range map[string]string{"out/index.HTML": HTMLEscaping, "values.yml": YAMLEscaping, "run.sh": ShellEscaping, "notes.txt": NoEscaping} {
+		if have := EscapingFromExtension(path); have != want {
+			t.Errorf("escaping of %s expected %s, have %s", path, want, have)
+		}
+	}
+}
+
+func TestExecuteStructured(t *testing.T) {
+	var variables map[string]interface{}
+	json.Unmarshal([]byte(`{"n": 3, "flag": true, "name": "O\"Neil", "host": "h", "none": null, "tags": ["a", "b"], "empty": {}, "config": {"port": 80, "hosts": ["x"]}}`), &variables)
+
+	tests := []struct {
+		structured string
+		template   string
+		want       string
+	}{
+		{JSONStructured, `{"count": {{n}}, "enabled": {{flag}}, "name": {{name}}, "none": {{none}}, "tags": {{tags}}}`, `{"count": 3, "enabled": true, "name": "O\"Neil", "none": null, "tags": ["a","b"]}`},
+		{JSONStructured, "{\n  \"config\": {{config}},\n  \"label\": \"{{name}} {{tags}}\"\n}", "{\n  \"config\": {\n    \"hosts\": [\n      \"x\"\n    ],\n    \"port\": 80\n  },\n  \"label\": \"O\\\"Neil [\\\"a\\\",\\\"b\\\"]\"\n}"},
+		{YAMLStructured, "app:\n  config: {{config}}\n  name: {{name}}\n  url: http://{{host}}:{{n}}\n  empty: {{empty}}\n  tags: [{{tags}}]", "app:\n  config:\n    hosts:\n      - x\n    port: 80\n  name: \"O\\\"Neil\"\n  url: http://h:3\n  empty: {}\n  tags: [[\"a\",\"b\"]]"},
+		{YAMLStructured, "items:\n  - {{tags}}\n  {{config}}", "items:\n  -\n    - a\n    - b\n  hosts:\n    - x\n  port: 80"},
+		{YAMLStructured, "cfg: {{config}}\nend: {{n}} ", "cfg:\n  hosts:\n    - x\n  port: 80\nend: 3 "},
+		{JSONStructured, `{"tags": {{ tags | json }}, "name": {{ name | json }}}`, `{"tags": ["a","b"], "name": "O\"Neil"}`},
+		{NoStructured, "{{name}} {{n}}", "O\"Neil 3"},
+	}
+
+	for i, tc := range tests {
+		compiled, err := Compile(tc.template, Options{Structured: tc.structured, Escaping: tc.structured})
+		if err != nil {
+			t.Fatalf("test #%d unexpected error : %v", i+1, err)
+		}
+		have, err := compiled.Execute(variables)
+		if err != nil || have != tc.want {
+			t.Errorf("test #%d failed expected result \n want : %s \n have : %s ( %v )", i+1, tc.want, have, err)
+		}
+	}
+
+	// the values outside of a value position are escaped as JSON strings even without escaping
+	unescaped := []struct {
+		structured string
+		template   string
+		want       string
+	}{
+		{JSONStructured, `{"label": "{{name}}", "config": "{{config}}", "count": {{n}}}`, `{"label": "O\"Neil", "config": "{\"hosts\":[\"x\"],\"port\":80}", "count": 3}`},
+		{YAMLStructured, "label: \"{{name}} {{tags}}\"\nname: {{name}}", "label: \"O\\\"Neil [\\\"a\\\",\\\"b\\\"]\"\nname: \"O\\\"Neil\""},
+	}
+	for i, tc := range unescaped {
+		compiled, err := Compile(tc.template, Options{Structured: tc.structured})
+		if err != nil {
+			t.Fatalf("unescaped test #%d unexpected error : %v", i+1, err)
+		}
+		have, err := compiled.Execute(variables)
+		if err != nil || have != tc.want {
+			t.Errorf("unescaped test #%d failed expected result \n want : %s \n have : %s ( %v )", i+1, tc.want, have, err)
+		}
+	}
+
+	var invalid *InvalidOptionError
+	if _, err := Compile("{{value}}", Options{Structured: "toml"}); !errors.As(err, &invalid) {
+		t.Errorf("expected an invalid option error, have : %v", err)
+	}
+
+	for path, want := range map[string]string{"out/values.YML": YAMLStructured, "data.json": JSONStructured, "index.html": NoStructured} {
+		if have := StructuredFromExtension(path); have != want {
+			t.Errorf("structured output of %s expected %s, have %s", path, want, have)
+		}
+	}
+}
+
+type testLoader struct {
+	templates map[string]string
+}
+
+func (l *testLoader) Load(name string) (string, string, error) {
+	source, ok := l.templates[name]
+	if !ok {
+		return "", "", fmt.Errorf("template not found")
+	}
+
+	return source, name, nil
+}
+
+func TestCompileIncludes(t *testing.T) {
+	loader := &testLoader{templates: map[string]string{
+		"header":  "# {{title}}\n",
+		"item":    "- {{name}}{{> \"suffix\" }}",
+		"suffix":  " ({{ @number }})",
+		"missing": "{{author}}",
+		"self":    "a {{> self }}",
+		"cycle":   "(items)[\n{{> \"loop\" }}\n]",
+		"loop":    "{{> cycle }}",
+		"broken":  "line\n(if x)[\nnever",
+	}}
+
+	compiled, err := Compile("{{> header }}\n(items)[\n{{> item }}\n]\n{{> missing }} {{date}}", Options{Name: "main", Loader: loader})
+	if err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+
+	have, report, err := compiled.ExecuteReport(map[string]interface{}{
+		"title": "Items",
+		"items": []interface{}{map[string]interface{}{"name": "a"}, map[string]interface{}{"name": "b"}},
+	})
+	want := "# Items\n- a (1)\n- b (2)\n{{author}} {{date}}"
+	if err != nil || have != want {
+		t.Errorf("failed expected result \n want : %s \n have : %s ( %v )", want, have, err)
+	}
+	if len(report.Missing) != 2 || report.Missing[0].Template != "missing" || report.Missing[0].Column != 3 || report.Missing[1].Template != "main" {
+		t.Errorf("unexpected report : %+v %+v", report.Missing[0], report.Missing[1])
+	}
+
+	errorsTests := []struct {
+		template string
+		want     string
+	}{
+		{
+			template: "{{> self }}",
+			want: "self:1:5: cannot include \"self\" : include cycle main -> self -> self\n" +
+				" 1 | a {{> self }}\n" +
+				"   |     ^",
+		},
+		{
+			template: "{{> cycle }}",
+			want: "loop:1:3: cannot include \"cycle\" : include cycle main -> cycle -> loop -> cycle\n" +
+				" 1 | {{> cycle }}\n" +
+				"   |   ^",
+		},
+		{
+			template: "first\n  {{> unknown }}",
+			want: "main:2:5: cannot include \"unknown\" : template not found\n" +
+				" 2 |   {{> unknown }}\n" +
+				"   |     ^",
+		},
+		{
+			template: "{{> broken }}",
+			want: "broken:2:1: block \"if x\" is not closed\n" +
+				" 2 | (if x)[\n" +
+				"   | ^",
+		},
+	}
+
+	for i, tc := range errorsTests {
+		_, err := Compile(tc.template, Options{Name: "main", Loader: loader})
+		var includeError *IncludeError
+		if err == nil || err.Error() != tc.want || (i < 3 && !errors.As(err, &includeError)) {
+			t.Errorf("test #%d failed expected result \n want : %s \n have : %v", i+1, tc.want, err)
+		}
+	}
+
+	if _, err := Compile("{{> header }}", Options{}); err == nil {
+		t.Errorf("expected an error including without loader")
+	}
+}
+
+func TestDirectoryLoader(t *testing.T) {
+	root := t.TempDir()
+	templates := filepath.Join(root, "templates")
+	os.MkdirAll(filepath.Join(templates, "partials"), 0755)
+	os.WriteFile(filepath.Join(templates, "partials", "header.txt"), []byte("# {{title}}\n"), 0644)
+	os.WriteFile(filepath.Join(root, "secret.txt"), []byte("secret"), 0644)
+
+	loader := NewDirectoryLoader(templates)
+	compiled, err := Compile("{{> partials/header.txt }}", Options{Loader: loader})
+	if err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+	if have, err := compiled.Execute(map[string]interface{}{"title": "x"}); err != nil || have != "# x" {
+		t.Errorf("unexpected result %q ( %v )", have, err)
+	}
+
+	for _, name := range []string{"../secret.txt", "partials/../../secret.txt", ".."} {
+		var includeError *IncludeError
+		if _, err := Compile("{{> "+name+" }}", Options{Loader: loader}); !errors.As(err, &includeError) || !strings.Contains(err.Error(), "outside of") {
+			t.Errorf("including %s expected an error, have : %v", name, err)
+		}
+	}
+}
+
+func TestCompileInheritance(t *testing.T) {
+	loader := &testLoader{templates: map[string]string{
+		"base": "<h1>{{title}}</h1>\n" +
+			"(block content)[\n" +
+			"<p>default</p>\n" +
+			"]\n" +
+			"(block footer)[\n" +
+			"<footer>{{> copyright }}</footer>\n" +
+			"]",
+		"copyright": "(c) {{owner}}",
+		"report": "{{< base }}\n" +
+			"(block content)[\n" +
+			"(rows)[\n" +
+			"<p>{{.}}</p>\n" +
+			"]\n" +
+			"]",
+		"signed": "{{< report }}\n" +
+			"(block footer)[\n" +
+			"{{ @super }}\n" +
+			"<i>{{signature}}</i>\n" +
+			"]",
+		"looping":   "{{< looping2 }}",
+		"looping2":  "{{< looping }}",
+		"misplaced": "(if x)[\n{{< base }}\n]",
+	}}
+
+	variables := map[string]interface{}{"title": "T", "rows": []interface{}{1, 2}, "owner": "me", "signature": "S"}
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"{{> base }}", "<h1>T</h1>\n<p>default</p>\n<footer>(c) me</footer>"},
+		{"{{< report }}\nignored {{title}}\n(block content)[\n<p>{{title}}</p>\n]", "<h1>T</h1>\n<p>T</p>\n<footer>(c) me</footer>"},
+		{"{{< signed }}", "<h1>T</h1>\n<p>1</p>\n<p>2</p>\n<footer>(c) me</footer>\n<i>S</i>"},
+		{"{{< signed }}\n(block content)[\nbefore\n{{ @super }}\n]", "<h1>T</h1>\nbefore\n<p>1</p>\n<p>2</p>\n<footer>(c) me</footer>\n<i>S</i>"},
+		{"{{< base }}\n(block content)[\n(missing)[\n{{.}}\n](else)[\nempty: {{ @super }}\n]\n]", "<h1>T</h1>\nempty: <p>default</p>\n<footer>(c) me</footer>"},
+	}
+
+	for i, tc := range tests {
+		compiled, err := Compile(tc.template, Options{Name: "main", Loader: loader})
+		if err != nil {
+			t.Fatalf("test #%d unexpected error : %v", i+1, err)
+		}
+		have, err := compiled.Execute(variables)
+		if err != nil || have != tc.want {
+			t.Errorf("test #%d failed expected result \n want : %s \n have : %s ( %v )", i+1, tc.want, have, err)
+		}
+	}
+
+	errorsTests := []struct {
+		template string
+		want     string
+	}{
+		{
+			template: "{{< looping }}",
+			want: "looping2:1:3: cannot extend \"looping\" : include cycle main -> looping -> looping2 -> looping\n" +
+				" 1 | {{< looping }}\n" +
+				"   |   ^",
+		},
+		{
+			template: "{{< misplaced }}",
+			want: "misplaced:2:3: an extends placeholder cannot be nested in a block\n" +
+				" 2 | {{< base }}\n" +
+				"   |   ^",
+		},
+		{
+			template: "{{< base }}\n(block a)[\n1\n]\n(block a)[\n2\n]",
+			want: "main:5:1: block \"a\" is already defined\n" +
+				" 5 | (block a)[\n" +
+				"   | ^",
+		},
+	}
+
+	for i, tc := range errorsTests {
+		if _, err := Compile(tc.template, Options{Name: "main", Loader: loader}); err == nil || err.Error() != tc.want {
+			t.Errorf("test #%d failed expected result \n want : %s \n have : %v", i+1, tc.want, err)
+		}
+	}
+
+	// the errors of an overriding block are located in the extending template
+	compiled, _ := Compile("{{< base }}\n(block content)[\n{{ owner! }}{{ title + 1 }}\n]", Options{Name: "main", Loader: loader})
+	if _, err := compiled.Execute(map[string]interface{}{}); err == nil || !strings.HasPrefix(err.Error(), "main:3:4: ") {
+		t.Errorf("unexpected error : %v", err)
+	}
+}
+
+func TestRenderMacros(t *testing.T) {
+	tests := []struct {
+		args testRender
+		want string
+	}{
+		{
+			args: testRender{
+				template: "(macro field name type)[\n" +
+					"\t{{ name | title }} {{type}}\n" +
+					"]\n" +
+					"type Row struct {\n" +
+					"(columns)[\n" +
+					"{{ field(name, type) }}\n" +
+					"]\n" +
+					"{{ field(\"extra\", \"bool\") }}\n" +
+					"}",
+				variables: `{"columns": [{"name": "id", "type": "int"}, {"name": "label", "type": "string"}]}`,
+			},
+			want: "type Row struct {\n" +
+				"\tId int\n" +
+				"\tLabel string\n" +
+				"\tExtra bool\n" +
+				"}",
+		},
+		{
+			args: testRender{
+				template: "(macro wrap, value)[\n" +
+					"[{{value}}|{{ label ?? \"none\" }}]\n" +
+					"]\n" +
+					"{{ wrap(1) }} {{ wrap(\"a\") | upper }} {{ unknown(1) }}",
+				variables: `{"label": "root"}`,
+			},
+			want: "[1|root] [A|ROOT] {{ unknown(1) }}",
+		},
+	}
+
+	runRenderTests(t, tests)
+
+	compiled, _ := Compile("(macro link url)[\n<a href=\"{{url}}\">{{url}}</a>\n]\n{{ link(u) }}", Options{Escaping: HTMLEscaping})
+	if have, err := compiled.Execute(map[string]interface{}{"u": "/?a=1&b=2"}); err != nil || have != "<a href=\"/?a=1&amp;b=2\">/?a=1&amp;b=2</a>" {
+		t.Errorf("unexpected escaped macro result : %s ( %v )", have, err)
+	}
+
+	errorsTests := []struct {
+		template string
+		want     string
+	}{
+		{
+			template: "(macro pair a b)[\n{{a}}{{b}}\n]\n{{ pair(1) }}",
+			want: "4:4: macro \"pair\" expects 2 argument(s), 1 given at position 0 of expression \"pair(1)\"\n" +
+				" 4 | {{ pair(1) }}\n" +
+				"   |    ^",
+		},
+		{
+			template: "(macro loop)[\n{{ loop() }}\n]\n{{ loop() }}",
+			want: "2:4: macro \"loop\" : more than 64 nested macro calls at position 0 of expression \"loop()\"\n" +
+				" 2 | {{ loop() }}\n" +
+				"   |    ^",
+		},
+		{
+			template: "(macro a)[\n{{ x! }}\n]\ntext\n{{ a() }}",
+			want: "2:4: variable : \"x\" not found in data\n" +
+				" 2 | {{ x! }}\n" +
+				"   |    ^",
+		},
+	}
+
+	for i, tc := range errorsTests {
+		_, err := Execute(tc.template, map[string]interface{}{}, Options{})
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("test #%d failed expected result \n want : %s \n have : %v", i+1, tc.want, err)
+		}
+	}
+
+	loader := &testLoader{templates: map[string]string{"macros": "(macro bold text)[\n**{{text}}**\n]"}}
+	compiled, err := Compile("{{> macros }}{{> macros }}{{ bold(\"x\") }}", Options{Loader: loader})
+	if err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+	if have, _ := compiled.Execute(nil); have != "**x**" {
+		t.Errorf("unexpected included macro result : %q", have)
+	}
+	if _, err := Compile("(macro a)[\n1\n]\n(macro a)[\n2\n]", Options{}); err == nil || !strings.Contains(err.Error(), "macro \"a\" is already defined") {
+		t.Errorf("expected a duplicated macro error, have : %v", err)
+	}
+}
+
+func TestRenderCommentsAndTrimMarkers(t *testing.T) {
+	tests := []struct {
+		args testRender
+		want string
+	}{
+		{
+			args: testRender{
+				template:  "a{{# a comment\nover lines }}b {{#c}}",
+				variables: `{}`,
+			},
+			want: "ab ",
+		},
+		{
+			args: testRender{
+				template: "all:\n" +
+					"{{- # targets -}}\n" +
+					"\t{{ target }}\n" +
+					"\n" +
+					"  {{- \" done\" }}",
+				variables: `{"target": "build"}`,
+			},
+			want: "all:build done",
+		},
+		{
+			args: testRender{
+				template: "list:\n" +
+					"  (items)(,)[\n" +
+					"  {{.}}\n" +
+					"  ]-\n" +
+					"\n" +
+					" ;",
+				variables: `{"items": [1, 2]}`,
+			},
+			want: "list:\n" +
+				"  1,\n" +
+				"  2;",
+		},
+		{
+			args: testRender{
+				template:  "{{-x }} {{ x -}} {{ x }}",
+				variables: `{"x": 1}`,
+			},
+			want: "-1 11",
+		},
+	}
+
+	runRenderTests(t, tests)
+}
+
+func TestRenderInlineLoops(t *testing.T) {
+	tests := []struct {
+		args testRender
+		want string
+	}{
+		{
+			args: testRender{
+				template:  `SELECT * FROM users WHERE id IN ((users)(", ")[{{id}}]);`,
+				variables: `{"users": [{"id": 1}, {"id": 2}, {"id": 3}]}`,
+			},
+			want: "SELECT * FROM users WHERE id IN (1, 2, 3);",
+		},
+		{
+			args: testRender{
+				template:  "(columns)(;)[\"{{ name | upper }}\"]\nrows",
+				variables: `{"columns": [{"name": "id"}, {"name": "label"}]}`,
+			},
+			want: "\"ID\";\"LABEL\"\nrows",
+		},
+		{
+			args: testRender{
+				template: "(rows)[\n" +
+					"  - (.)(,)[{{.}}] ({{ @length }})\n" +
+					"]",
+				variables: `{"rows": [[1, 2], [3]]}`,
+			},
+			want: "- 1,2 (2)\n" +
+				"- 3 (2)",
+		},
+		{
+			args: testRender{
+				template:  "(groups)( | )[{{name}}: (items)(,)[{{.}}]]",
+				variables: `{"groups": [{"name": "a", "items": [1, 2]}, {"name": "b", "items": []}]}`,
+			},
+			want: "a: 1,2 | b: ",
+		},
+		{
+			args: testRender{
+				template:  "f(x)[0] + g(name)[1] + (tags)[{{.}}\n]",
+				variables: `{"name": "n", "tags": ["t"]}`,
+			},
+			want: "f(x)[0] + g(name)[1] + (tags)[{{.}}\n]",
+		},
+		{
+			args: testRender{
+				template:  "v = f(xs)[0]; w = m.get(xs)[1]; (xs)(,)[{{.}}]",
+				variables: `{"xs": [1, 2]}`,
+			},
+			want: "v = f(xs)[0]; w = m.get(xs)[1]; 1,2",
+		},
+		{
+			args: testRender{
+				template: "(items)(\", \")[\n" +
+					"{{.}}\n" +
+					"]",
+				variables: `{"items": [1, 2]}`,
+			},
+			want: "1, \n" +
+				"2",
+		},
+	}
+
+	runRenderTests(t, tests)
+}
+
+func TestRenderLoopElse(t *testing.T) {
+	tests := []struct {
+		args testRender
+		want string
+	}{
+		{
+			args: testRender{
+				template: "users:\n" +
+					"  (users)[\n" +
+					"  - {{name}}\n" +
+					"  ](else)[\n" +
+					"  - nobody\n" +
+					"  ]\n" +
+					"end",
+				variables: `{"users": []}`,
+			},
+			want: "users:\n" +
+				"  - nobody\n" +
+				"end",
+		},
+		{
+			args: testRender{
+				template: "users:\n" +
+					"(users)[\n" +
+					"- {{name}}\n" +
+					"]\n" +
+					"(else)[\n" +
+					"none\n" +
+					"]\n" +
+					"end",
+				variables: `{"users": [{"name": "a"}]}`,
+			},
+			want: "users:\n" +
+				"- a\n" +
+				"end",
+		},
+		{
+			args: testRender{
+				template: "start\n" +
+					"(users)[\n" +
+					"- {{name}}\n" +
+					"]\n" +
+					"end",
+				variables: `{}`,
+			},
+			want: "start\n" +
+				"end",
+		},
+		{
+			args: testRender{
+				template:  "IN ((ids)(, )[{{.}}](else)[NULL]) (tags)[#{{.}}](else)[-]",
+				variables: `{"tags": ["a"]}`,
+			},
+			want: "IN (NULL) #a",
+		},
+	}
+
+	runRenderTests(t, tests)
+
+	if have, err := Execute("(items)[\n{{.}}\n](else)[\n(macro none)[\n-\n]\n{{ none() }}\n]", nil, Options{}); err != nil || have != "-" {
+		t.Errorf("unexpected result of a macro defined in an else branch : %s ( %v )", have, err)
+	}
+
+	if _, err := Compile("(items)[\nx\n](elif y)[\ny\n]", Options{}); err == nil || !strings.Contains(err.Error(), "\"elif y\" branch following a loop block") {
+		t.Errorf("expected an elif after loop error, have : %v", err)
+	}
+
+	strict := Options{Name: "t", PanicIfMissingLoop: true}
+	_, err := Execute("a\n(usres)[\n{{name}}\n]", map[string]interface{}{"users": []interface{}{}}, strict)
+	var missing *MissingVariableError
+	if !errors.As(err, &missing) || missing.Variable != "usres" || missing.Line != 2 || missing.Column != 2 {
+		t.Errorf("expected a missing loop variable error, have : %v", err)
+	}
+	if have, err := Execute("(usres)[\n{{name}}\n](else)[\nnone\n]", nil, strict); err != nil || have != "none" {
+		t.Errorf("unexpected result of a missing loop with else branch : %s ( %v )", have, err)
+	}
+	if have, err := Execute("(users)[\n{{name}}\n]", map[string]interface{}{"users": []interface{}{}}, strict); err != nil || have != "" {
+		t.Errorf("unexpected result of an empty loop : %s ( %v )", have, err)
+	}
+}
+
+func TestRenderMapLoops(t *testing.T) {
+	tests := []struct {
+		args testRender
+		want string
+	}{
+		{
+			args: testRender{
+				template: "env:\n" +
+					"  (env)[\n" +
+					"  {{@key}}={{.}}\n" +
+					"  ]",
+				variables: `{"env": {"B": "2", "A": "1", "C": 3}}`,
+			},
+			want: "env:\n" +
+				"  A=1\n" +
+				"  B=2\n" +
+				"  C=3",
+		},
+		{
+			args: testRender{
+				template: "(services)[\n" +
+					"{{@number}}. {{@key}} on {{port}}\n" +
+					"]",
+				variables: `{"services": {"web": {"port": 80}, "api": {"port": 8080}}}`,
+			},
+			want: "1. api on 8080\n" +
+				"2. web on 80",
+		},
+		{
+			args: testRender{
+				template:  "(env)(&)[{{@key}}={{@value}}](else)[none] (empty)[x](else)[none]",
+				variables: `{"env": {"b": "2", "a": "1"}, "empty": {}}`,
+			},
+			want: "a=1&b=2 none",
+		},
+	}
+
+	runRenderTests(t, tests)
+
+	// maps sharing their keys keep their own order, and the maps not added are sorted
+	first := map[string]interface{}{"b": 1, "a": 2}
+	second := map[string]interface{}{"a": 1, "b": 2}
+	order := NewInsertionOrder()
+	order.Add(first, []string{"b", "a"})
+	order.Add(second, []string{"a", "b"})
+	variables := map[string]interface{}{"objects": []interface{}{first, second, map[string]interface{}{"b": 1, "a": 2}}}
+
+	compiled, err := Compile("(objects)(;)[(.)(,)[{{@key}}]]", Options{})
+	if err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+	var b strings.Builder
+	if _, err := compiled.ExecuteTo(&b, variables, order); err != nil || b.String() != "b,a;a,b;a,b" {
+		t.Errorf("unexpected result of a loop in insertion order : %s ( %v )", b.String(), err)
+	}
+}
+
+func benchmarkVariables(rows int) map[string]interface{} {
+	elements := make([]interface{}, 0, rows)
+	for i := 0; i < rows; i++ {
+		elements = append(elements, map[string]interface{}{
+			"id":    fmt.Sprint(i),
+			"name":  fmt.Sprintf("name %d", i),
+			"email": fmt.Sprintf("user%d@example.com", i),
+			"admin": i%3 == 0,
+		})
+	}
+
+	return map[string]interface{}{"title": "users", "rows": elements}
+}
+
+const benchmarkTemplate = "{\n" +
+	"  \"title\": \"{{ title }}\",\n" +
+	"  \"rows\": [\n" +
+	"    (rows)(,)[\n" +
+	"    {\n" +
+	"      \"id\": {{ id }},\n" +
+	"      \"name\": \"{{ name | upper }}\",\n" +
+	"      \"email\": \"{{ email }}\"\n" +
+	"      (if admin)[\n" +
+	"      , \"admin\": true\n" +
+	"      ]\n" +
+	"    }\n" +
+	"    ]\n" +
+	"  ]\n" +
+	"}"
+
+func BenchmarkRender5000Rows(b *testing.B) {
+	variables := benchmarkVariables(5000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Render(benchmarkTemplate, variables, "", "", "", "", "", "", false)
+	}
+}
+
+func BenchmarkExecute5000Rows(b *testing.B) {
+	variables := benchmarkVariables(5000)
+	compiled, err := Compile(benchmarkTemplate, Options{})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := compiled.Execute(variables); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCompile(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if _, err := Compile(benchmarkTemplate, Options{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestCompileExecuteConcurrently(t *testing.T) {
+	compiled, err := Compile("(users)(,)[\n{{ @number }}:{{ name | upper }}\n]", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			variables := map[string]interface{}{"users": []interface{}{
+				map[string]interface{}{"name": fmt.Sprint("a", i)},
+				map[string]interface{}{"name": fmt.Sprint("b", i)},
+			}}
+			want := fmt.Sprintf("1:A%d,\n2:B%d", i, i)
+
+			out, err := compiled.Execute(variables)
+			if err != nil || out != want {
+				t.Errorf("goroutine #%d failed expected result \n want : %s \n have : %s ( %v )", i, want, out, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestCache(t *testing.T) {
+	cache := NewCache(2)
+
+	a, _ := cache.Compile("{{a}}", Options{})
+	if again, _ := cache.Compile("{{a}}", Options{LeftDelimiter: "{{"}); again != a {
+		t.Errorf("expected the compiled template to be reused for equivalent options")
+	}
+	if other, _ := cache.Compile("{{a}}", Options{LeftDelimiter: "<<"}); other == a {
+		t.Errorf("expected a new compiled template for other options")
+	}
+
+	// "{{a}}" with the default options is the least recently used one once "{{b}}" is compiled
+	cache.Compile("{{a}}", Options{LeftDelimiter: "<<"})
+	cache.Compile("{{b}}", Options{})
+	if cache.Len() != 2 {
+		t.Errorf("expected 2 templates in the cache, have %d", cache.Len())
+	}
+	if again, _ := cache.Compile("{{a}}", Options{}); again == a {
+		t.Errorf("expected the least recently used template to be evicted")
+	}
+
+	if _, err := cache.Compile("(else)[\nx\n]", Options{}); err == nil {
+		t.Errorf("expected a compilation error")
+	}
+
+	// a template is compiled again once the templates it includes change
+	loader := &testLoader{templates: map[string]string{"header": "old"}}
+	included, _ := cache.Compile("{{> header }}", Options{Loader: loader})
+	if again, _ := cache.Compile("{{> header }}", Options{Loader: loader}); again != included {
+		t.Errorf("expected the compiled template to be reused while its includes are unchanged")
+	}
+	loader.templates["header"] = "new"
+	updated, err := cache.Compile("{{> header }}", Options{Loader: loader})
+	if have, _ := updated.Execute(nil); err != nil || have != "new" {
+		t.Errorf("expected the updated include to be rendered, have %q ( %v )", have, err)
+	}
+
+	// templates compiled with a loader which cannot key the cache are not cached
+	size := cache.Len()
+	compiled, err := cache.Compile("{{> header }}", Options{Loader: mapLoader{"header": "map"}})
+	if have, _ := compiled.Execute(nil); err != nil || have != "map" || cache.Len() != size {
+		t.Errorf("unexpected result %q ( %v ), %d templates in the cache", have, err, cache.Len())
+	}
+}
+
+type mapLoader map[string]string
+
+func (l mapLoader) Load(name string) (string, string, error) {
+	return l[name], name, nil
+}
+
+func TestExecuteErrors(t *testing.T) {
+	tests := []struct {
+		args    testRender
+		options Options
+		want    string
+	}{
+		{
+			args:    testRender{template: "a\n  (users)[\n  {{ name }}\n", variables: `{}`},
+			options: Options{Name: "users.txt"},
+			want: "users.txt:2:3: block \"users\" is not closed\n" +
+				" 2 |   (users)[\n" +
+				"   |   ^",
+		},
+		{
+			args:    testRender{template: "(if a)[\na\n](else)[\nb", variables: `{}`},
+			options: Options{},
+			want: "3:2: block \"else\" is not closed\n" +
+				" 3 | ](else)[\n" +
+				"   |  ^",
+		},
+		{
+			args:    testRender{template: "id: {{ id }}\nname: {{ name }}\nmail: {{ mail }}", variables: `{"id": 1}`},
+			options: Options{Name: "user.yaml", PanicIfNoMatch: true},
+			want: "user.yaml:2:10: variable : \"name\" not found in data\n" +
+				" 2 | name: {{ name }}\n" +
+				"   |          ^\n" +
+				"user.yaml:3:10: variable : \"mail\" not found in data\n" +
+				" 3 | mail: {{ mail }}\n" +
+				"   |          ^",
+		},
+		{
+			args:    testRender{template: "(items)[\n  (if  . > 1 and  .name == 'x')[\n  {{ . }}\n  ]\n]", variables: `{"items": [2, {"name": "x"}]}`},
+			options: Options{},
+			want: "2:10: cannot compare map[string]interface {} and float64 with \">\" at position 2 of expression \". > 1 and  .name == 'x'\"\n" +
+				" 2 |   (if  . > 1 and  .name == 'x')[\n" +
+				"   |          ^",
+		},
+		{
+			args:    testRender{template: "é {{ name | upper: 1 }}", variables: `{"name": "a"}`},
+			options: Options{},
+			want: "1:13: filter \"upper\" : expects 0 argument(s), 1 given at position 7 of expression \"name | upper: 1\"\n" +
+				" 1 | é {{ name | upper: 1 }}\n" +
+				"   |             ^",
+		},
+		{
+			args:    testRender{template: "{{ a }}", variables: `{}`},
+			options: Options{LeftLoopVariableDelimiter: "[", LeftLoopBlockDelimiter: "["},
+			want:    `invalid left loop block delimiter "[" : must differ from the left loop variable delimiter`,
+		},
+		{
+			args:    testRender{template: "{{ a }}", variables: `{}`},
+			options: Options{RightDelimiter: "} }"},
+			want:    `invalid right delimiter "} }" : delimiters cannot contain white spaces`,
+		},
+	}
+
+	for i, tc := range tests {
+		var variables map[string]interface{}
+		if err := json.Unmarshal([]byte(tc.args.variables), &variables); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := Execute(tc.args.template, variables, tc.options)
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("test #%d failed expected result \n want : %s \n have : %v", i+1, tc.want, err)
+		}
+	}
+}
+
+func TestExecuteReport(t *testing.T) {
+	var variables map[string]interface{}
+	json.Unmarshal([]byte(`{"users": [{"name": "a"}, {"name": "b", "role": "admin"}, {"name": "c"}]}`), &variables)
+
+	compiled, err := Compile("{{ title }}\n(users)[\n{{ name }} {{ role }} {{ team | upper }}\n]\n{{ footer ?? \"-\" }}", Options{Name: "users.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rendered, report, err := compiled.ExecuteReport(variables)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "{{ title }}\na {{ role }} {{ team | upper }}\nb admin {{ team | upper }}\nc {{ role }} {{ team | upper }}\n-"
+	if rendered != want {
+		t.Errorf("unexpected rendering \n want : %s \n have : %s", want, rendered)
+	}
+
+	encoded, _ := json.Marshal(report)
+	wantReport := `{"missing":[` +
+		`{"template":"users.txt","line":1,"column":4,"excerpt":"{{ title }}","variable":"title"},` +
+		`{"template":"users.txt","line":3,"column":15,"excerpt":"{{ name }} {{ role }} {{ team | upper }}","variable":"role"},` +
+		`{"template":"users.txt","line":3,"column":26,"excerpt":"{{ name }} {{ role }} {{ team | upper }}","variable":"team | upper"}` +
+		`]}`
+	if string(encoded) != wantReport {
+		t.Errorf("unexpected report \n want : %s \n have : %s", wantReport, encoded)
+	}
+
+	compiled, _ = Compile("{{ a }} {{ b }}", Options{PanicIfNoMatch: true})
+	_, report, err = compiled.ExecuteReport(nil)
+	var missing *MissingVariablesError
+	if !errors.As(err, &missing) || len(missing.Missing) != 2 || len(report.Missing) != 2 {
+		t.Errorf("expected the two missing variables in the error and the report, have : %v", err)
+	}
+}
+
+// Iterable over a list, counting the iterations and the elements read, and failing after a number of elements when set
+type testIterable struct {
+	elements   []interface{}
+	failAfter  int
+	iterations int
+	read       int
+}
+
+type testIterator struct {
+	iterable *testIterable
+	cursor   int
+}
+
+func (l *testIterable) Iterate() (Iterator, error) {
+	l.iterations++
+	return &testIterator{iterable: l}, nil
+}
+
+func (it *testIterator) Next() (interface{}, bool, error) {
+	if it.iterable.failAfter > 0 && it.cursor == it.iterable.failAfter {
+		return nil, false, fmt.Errorf("broken record")
+	}
+	if it.cursor >= len(it.iterable.elements) {
+		return nil, false, nil
+	}
+
+	it.cursor++
+	it.iterable.read++
+	return it.iterable.elements[it.cursor-1], true, nil
+}
+
+func (it *testIterator) Close() error {
+	return nil
+}
+
+// Writer failing once a number of bytes is written
+type failingWriter struct {
+	limit   int
+	written int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.written+len(p) > w.limit {
+		return 0, fmt.Errorf("disk full")
+	}
+
+	w.written += len(p)
+	return len(p), nil
+}
+
+func TestExecuteTo(t *testing.T) {
+	records := &testIterable{elements: []interface{}{
+		map[string]interface{}{"sku": "a", "price": 1.5},
+		map[string]interface{}{"sku": "b", "price": 1000000.0},
+		"c",
+	}}
+	variables := map[string]interface{}{"records": records, "empty": &testIterable{}}
+
+	compiled, err := Compile("INSERT INTO t VALUES\n(records)(,)[\n({{@number}}, '{{sku ?? .}}', {{price ?? 0}}, {{@last}}, {{@length}})\n];\n(empty)[\nx\n](else)[\nnone\n]\n(records)(|)[{{@index}}]", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	report, err := compiled.ExecuteTo(&b, variables, nil)
+	want := "INSERT INTO t VALUES\n" +
+		"(1, 'a', 1.5, false, {{@length}}),\n" +
+		"(2, 'b', 1000000, false, {{@length}}),\n" +
+		"(3, 'c', 0, true, {{@length}});\n" +
+		"none\n" +
+		"0|1|2"
+	if err != nil || b.String() != want {
+		t.Errorf("unexpected rendering \n want : %s \n have : %s ( %v )", want, b.String(), err)
+	}
+	if records.iterations != 2 || len(report.Missing) != 1 {
+		t.Errorf("expected an iteration for each loop block and the length reported missing, have %d iteration(s) and %d missing", records.iterations, len(report.Missing))
+	}
+
+	broken := &testIterable{elements: []interface{}{"a", "b", "c"}, failAfter: 2}
+	_, err = compiled.ExecuteTo(&b, map[string]interface{}{"records": broken}, nil)
+	var iteration *IterationError
+	if !errors.As(err, &iteration) || iteration.Variable != "records" || iteration.Line != 2 || err.Error() != "2:2: cannot iterate over loop variable \"records\" : broken record\n 2 | (records)(,)[\n   |  ^" {
+		t.Errorf("expected a located iteration error, have : %v", err)
+	}
+
+	// the rendering stops at the first write error
+	endless := &testIterable{elements: make([]interface{}, 100000)}
+	writer := &failingWriter{limit: 64}
+	compiled, _ = Compile("(records)[\n{{@index}}\n]", Options{})
+	if _, err := compiled.ExecuteTo(writer, map[string]interface{}{"records": endless}, nil); err == nil || err.Error() != "disk full" || endless.read > 100 {
+		t.Errorf("expected the write error before reading all the elements, have : %v after %d element(s)", err, endless.read)
+	}
+}
@@ -0,0 +1,163 @@
+package rendering
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Structured output modes, serializing the values inserted in a JSON or YAML output as valid fragments instead of their text
+const (
+	// mode chosen from the extension of the output path, resolved with StructuredFromExtension by the callers knowing it
+	AutoStructured = "auto"
+	NoStructured   = "none"
+	JSONStructured = "json"
+	YAMLStructured = "yaml"
+)
+
+var structuredModes = map[string]bool{
+	NoStructured:   true,
+	JSONStructured: true,
+	YAMLStructured: true,
+}
+
+// Structured output modes by output file extension
+var extensionsStructured = map[string]string{
+	".json": JSONStructured,
+	".yaml": YAMLStructured,
+	".yml":  YAMLStructured,
+}
+
+// Structured output mode matching the extension of an output path, none for unknown extensions
+func StructuredFromExtension(path string) string {
+	if structured, ok := extensionsStructured[strings.ToLower(filepath.Ext(path))]; ok {
+		return structured
+	}
+
+	return NoStructured
+}
+
+// Whether the text following a placeholder ends the value it sits in, such as a line break, a comma or a closing bracket
+func closesValue(text string) bool {
+	text = strings.TrimLeft(text, " \t")
+
+	return text == "" || strings.ContainsAny(text[:1], "\r\n,]}")
+}
+
+// Whether a placeholder written after the text of the current output line starts a value, such as after "key: " or at the beginning of the line.
+// In YAML, a key or a list dash is followed by a white space, unlike the colons and dashes of plain scalars such as http://host or web-1.
+func opensValue(line string, structured string) bool {
+	trimmed := strings.TrimRight(line, " \t")
+	if trimmed == "" {
+		return true
+	}
+
+	switch last := trimmed[len(trimmed)-1]; {
+	case last == '[' || last == ',':
+		return true
+	case structured == JSONStructured:
+		return last == ':'
+	case last == ':':
+		return len(trimmed) < len(line)
+	case last == '-':
+		dash := len(trimmed) - 1
+		return len(trimmed) < len(line) && (dash == 0 || trimmed[dash-1] == ' ' || trimmed[dash-1] == '\t')
+	}
+
+	return false
+}
+
+// Leading white spaces of a line
+func lineIndentation(line string) string {
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+func marshalJSON(value interface{}, indentation string) (string, error) {
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	if indentation != "" {
+		encoder.SetIndent(indentation, "  ")
+	}
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(b.String(), "\n"), nil
+}
+
+func marshalYAML(value interface{}, indentation string) (string, error) {
+	var b bytes.Buffer
+	encoder := yaml.NewEncoder(&b)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+
+	lines := strings.Split(strings.TrimRight(b.String(), "\n"), "\n")
+	return strings.Join(lines, "\n"+indentation), nil
+}
+
+// Text of a value inserted in a structured output, not ok when the value is written as text.
+// In a value position, strings are quoted, null is written as null and objects and lists are serialized following the indentation of the line,
+// a YAML block starting on the next line after a key or a list dash.
+// Elsewhere, such as inside a quoted string, objects and lists are serialized as compact JSON, also valid YAML, to be escaped like strings.
+func structuredValue(value interface{}, structured string, line string, closes bool) (text string, escape bool, ok bool, err error) {
+	_, isMap := value.(map[string]interface{})
+	_, isList := value.([]interface{})
+	inValue := closes && opensValue(line, structured)
+
+	switch {
+	case !isMap && !isList:
+		if !inValue {
+			return "", false, false, nil
+		}
+		switch value.(type) {
+		case string:
+			text, err = marshalJSON(value, "")
+			return text, false, true, err
+		case nil:
+			return "null", false, true, nil
+		}
+		return "", false, false, nil
+	case !inValue:
+		text, err = marshalJSON(value, "")
+		return text, true, true, err
+	}
+
+	indentation := lineIndentation(line)
+	if structured == JSONStructured {
+		text, err = marshalJSON(value, indentation)
+		return text, false, true, err
+	}
+
+	trimmed := strings.TrimRight(line, " \t")
+	switch {
+	case isEmptyCollection(value) || (trimmed != "" && !strings.HasSuffix(trimmed, ":") && !strings.HasSuffix(trimmed, "-")):
+		// a YAML flow value, such as an empty one or one following a bracket or a comma
+		text, err = marshalJSON(value, "")
+	case trimmed == "":
+		// a block spliced at the indentation of the line
+		text, err = marshalYAML(value, indentation)
+	default:
+		// a block nested under the key or the list dash of the line, the white spaces following them being dropped by the output
+		text, err = marshalYAML(value, indentation+"  ")
+		text = "\n" + indentation + "  " + text
+	}
+
+	return text, false, true, err
+}
+
+func isEmptyCollection(value interface{}) bool {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return len(v) == 0
+	case []interface{}:
+		return len(v) == 0
+	}
+
+	return false
+}
@@ -0,0 +1,296 @@
+package rendering
+
+import (
+	"container/list"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Number of compiled templates kept by the cache of Render
+const DefaultCacheSize = 128
+
+// Options of a template compilation, the empty delimiters being replaced with the default ones
+type Options struct {
+	// Name of the template in the errors, such as its path
+	Name                       string
+	LeftDelimiter              string
+	RightDelimiter             string
+	LeftLoopVariableDelimiter  string
+	RightLoopVariableDelimiter string
+	LeftLoopBlockDelimiter     string
+	RightLoopBlockDelimiter    string
+	// Whether the execution fails when a variable without fallback is not found in the data
+	PanicIfNoMatch bool
+	// Whether the execution fails when the variable of a loop block without else branch is not found in the data
+	PanicIfMissingLoop bool
+	// Escaping of the inserted values, one of the escaping modes ( no escaping when empty )
+	Escaping string
+	// Loader of the templates included with include placeholders
+	Loader Loader
+	// Structured output mode serializing the inserted strings, objects and lists as JSON or YAML, one of the structured output modes ( none when empty )
+	Structured string
+}
+
+func (o Options) withDefaults() Options {
+	if len(o.LeftDelimiter) == 0 {
+		o.LeftDelimiter = "{{"
+	}
+	if len(o.RightDelimiter) == 0 {
+		o.RightDelimiter = "}}"
+	}
+	if len(o.LeftLoopVariableDelimiter) == 0 {
+		o.LeftLoopVariableDelimiter = "("
+	}
+	if len(o.RightLoopVariableDelimiter) == 0 {
+		o.RightLoopVariableDelimiter = ")"
+	}
+	if len(o.LeftLoopBlockDelimiter) == 0 {
+		o.LeftLoopBlockDelimiter = "["
+	}
+	if len(o.RightLoopBlockDelimiter) == 0 {
+		o.RightLoopBlockDelimiter = "]"
+	}
+	if len(o.Escaping) == 0 {
+		o.Escaping = NoEscaping
+	}
+	if len(o.Structured) == 0 {
+		o.Structured = NoStructured
+	}
+
+	return o
+}
+
+// Check that the delimiters can be told apart from the content and from each other, and that the escaping and structured output modes are known
+func (o Options) validate() error {
+	options := []struct {
+		name      string
+		delimiter string
+	}{
+		{"left delimiter", o.LeftDelimiter},
+		{"right delimiter", o.RightDelimiter},
+		{"left loop variable delimiter", o.LeftLoopVariableDelimiter},
+		{"right loop variable delimiter", o.RightLoopVariableDelimiter},
+		{"left loop block delimiter", o.LeftLoopBlockDelimiter},
+		{"right loop block delimiter", o.RightLoopBlockDelimiter},
+	}
+
+	for _, option := range options {
+		if strings.IndexFunc(option.delimiter, unicode.IsSpace) != -1 {
+			return &InvalidDelimiterError{Option: option.name, Delimiter: option.delimiter, Reason: "delimiters cannot contain white spaces"}
+		}
+	}
+
+	if o.LeftLoopVariableDelimiter == o.LeftLoopBlockDelimiter {
+		return &InvalidDelimiterError{Option: "left loop block delimiter", Delimiter: o.LeftLoopBlockDelimiter, Reason: "must differ from the left loop variable delimiter"}
+	}
+	if o.RightLoopVariableDelimiter == o.RightLoopBlockDelimiter {
+		return &InvalidDelimiterError{Option: "right loop block delimiter", Delimiter: o.RightLoopBlockDelimiter, Reason: "must differ from the right loop variable delimiter"}
+	}
+	if o.LeftDelimiter == o.LeftLoopVariableDelimiter {
+		return &InvalidDelimiterError{Option: "left loop variable delimiter", Delimiter: o.LeftLoopVariableDelimiter, Reason: "must differ from the left delimiter"}
+	}
+
+	if _, ok := escapers[o.Escaping]; !ok {
+		return &InvalidOptionError{Option: "escaping", Value: o.Escaping, Reason: "unknown escaping mode"}
+	}
+	if !structuredModes[o.Structured] {
+		return &InvalidOptionError{Option: "structured output", Value: o.Structured, Reason: "unknown structured output mode"}
+	}
+
+	return nil
+}
+
+func (o Options) delimiters() *delimiters {
+	return &delimiters{
+		left:              o.LeftDelimiter,
+		right:             o.RightDelimiter,
+		leftLoopVariable:  o.LeftLoopVariableDelimiter,
+		rightLoopVariable: o.RightLoopVariableDelimiter,
+		leftLoopBlock:     o.LeftLoopBlockDelimiter,
+		rightLoopBlock:    o.RightLoopBlockDelimiter,
+	}
+}
+
+// Compiled template, safe to execute from many goroutines
+type Template struct {
+	nodes   []Node
+	options Options
+	source  string
+	macros  map[string]*macroNode
+	// sources of the included and extended templates by name, to tell whether the template is still up to date
+	loaded map[string]string
+}
+
+// Compile a template once to execute it against many sets of variables
+func Compile(template string, options Options) (*Template, error) {
+	options = options.withDefaults()
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+
+	includes := &includer{loader: options.Loader, d: options.delimiters(), stack: []string{options.Name}, loaded: make(map[string]string)}
+	parsed, err := parse(template, options.delimiters(), includes)
+	if err != nil {
+		return nil, locateError(err, options.Name, template)
+	}
+	nodes, err := includes.extend(parsed, options.Name, template)
+	if err != nil {
+		return nil, locateError(err, options.Name, template)
+	}
+
+	// the macros of an extending template are defined outside of the blocks it renders
+	macros := make(map[string]*macroNode)
+	if err := collectMacros(macros, parsed, options.Name, template); err != nil {
+		return nil, locateError(err, options.Name, template)
+	}
+	if err := collectMacros(macros, nodes, options.Name, template); err != nil {
+		return nil, locateError(err, options.Name, template)
+	}
+
+	return &Template{nodes: nodes, options: options, source: template, macros: macros, loaded: includes.loaded}, nil
+}
+
+// Whether the templates included or extended by the template are unchanged, loading them again
+func (t *Template) upToDate() bool {
+	for name, source := range t.loaded {
+		loaded, _, err := t.options.Loader.Load(name)
+		if err != nil || loaded != source {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Report of the placeholders of a template left untouched by an execution because their variables were not found in the data
+type Report struct {
+	Missing []*MissingVariableError `json:"missing"`
+}
+
+// Execute the template against a map of variables, in a single pass.
+// The placeholders whose variables are not found are left untouched, unless the PanicIfNoMatch option is set.
+func (t *Template) Execute(variables map[string]interface{}) (string, error) {
+	rendered, _, err := t.ExecuteReport(variables)
+	return rendered, err
+}
+
+// Execute the template against a map of variables and report all the placeholders whose variables are not found.
+// With the PanicIfNoMatch option set, the report comes with an error listing them when it is not empty.
+func (t *Template) ExecuteReport(variables map[string]interface{}) (string, *Report, error) {
+	var b strings.Builder
+	report, err := t.ExecuteTo(&b, variables, nil)
+	if err != nil {
+		return "", report, err
+	}
+
+	return b.String(), report, nil
+}
+
+// Execute the template against a map of variables, writing the output as it is rendered, and report the placeholders whose variables are not found.
+// The loop variables can be Iterables producing their elements as they are rendered, keeping the memory use bounded whatever the size of the data.
+// The maps are iterated by the loop blocks in the key order of the data, such as an InsertionOrder, or in lexical order when it is nil.
+// The output already written is kept when an error occurs, the error of the PanicIfNoMatch option being known once the whole template is written.
+func (t *Template) ExecuteTo(w io.Writer, variables map[string]interface{}, keyOrder KeyOrder) (*Report, error) {
+	state := &renderState{name: t.options.Name, source: t.source, escape: escapers[t.options.Escaping], panicIfMissingLoop: t.options.PanicIfMissingLoop, keyOrder: keyOrder, structured: t.options.Structured}
+
+	root := NewScope(variables, nil)
+	bindMacros(t.macros, state, root)
+
+	if err := renderOutput(w, t.nodes, root, state); err != nil {
+		return nil, locateError(err, t.options.Name, t.source)
+	}
+
+	report := state.report()
+	if t.options.PanicIfNoMatch && len(report.Missing) > 0 {
+		return report, &MissingVariablesError{Missing: report.Missing}
+	}
+
+	return report, nil
+}
+
+type cacheKey struct {
+	template string
+	options  Options
+}
+
+type cacheEntry struct {
+	key      cacheKey
+	template *Template
+}
+
+// Cache of compiled templates keyed by their content and options, evicting the least recently used ones. Safe for concurrent use.
+type Cache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[cacheKey]*list.Element
+	order    *list.List
+}
+
+func NewCache(capacity int) *Cache {
+	return &Cache{
+		capacity: capacity,
+		entries:  make(map[cacheKey]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Whether options can key the cache, their loader being comparable
+func cacheable(options Options) bool {
+	return options.Loader == nil || reflect.TypeOf(options.Loader).Comparable()
+}
+
+// Compile a template, or return the one already compiled with the same content and options.
+// A template whose included or extended templates changed since its compilation is compiled again.
+func (c *Cache) Compile(template string, options Options) (*Template, error) {
+	if !cacheable(options) {
+		return Compile(template, options)
+	}
+	key := cacheKey{template: template, options: options.withDefaults()}
+
+	c.mu.Lock()
+	element, ok := c.entries[key]
+	if ok {
+		c.order.MoveToFront(element)
+	}
+	c.mu.Unlock()
+
+	if ok {
+		// checked outside of the lock, the loader reading the included templates
+		if cached := element.Value.(*cacheEntry).template; cached.upToDate() {
+			return cached, nil
+		}
+	}
+
+	// compiled outside of the lock : concurrent compilations of the same template give equivalent templates
+	compiled, err := Compile(template, options)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.order.Remove(element)
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, template: compiled})
+	for c.capacity > 0 && c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+
+	return compiled, nil
+}
+
+// Number of templates in the cache
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
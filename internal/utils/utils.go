@@ -40,14 +40,19 @@ func ReadFileContent(filePath string) (string, error) {
 	return string(fileRawContent), nil
 }
 
-func WriteFileContent(path string, content string) error {
+// Create a file for writing, with its directory hierarchy
+func CreateFile(path string) (*os.File, error) {
 	// prepare dir hierarchy
 	if _, err := os.Stat(filepath.Dir(path)); os.IsNotExist(err) {
 		os.MkdirAll(filepath.Dir(path), 0777)
 	}
 
+	return os.Create(path)
+}
+
+func WriteFileContent(path string, content string) error {
 	// write content
-	f, err := os.Create(path)
+	f, err := CreateFile(path)
 	if err != nil {
 		return err
 	}
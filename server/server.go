@@ -1,18 +1,24 @@
 package server
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
 
+	"github.com/sebps/template-engine/internal/parsing"
 	"github.com/sebps/template-engine/internal/rendering"
 )
 
 const TEMPLATE_DIR = "../templates"
 
+// Number of compiled templates kept in memory between /Render calls
+const TEMPLATE_CACHE_SIZE = 128
+
 type RequestHandler func(w http.ResponseWriter, r *http.Request)
 
 type HttpHandler struct {
@@ -46,6 +52,8 @@ func Serve(
 			Pattern: "/Render",
 			Method:  "POST",
 			Handler: getRenderHandler(
+				rendering.NewCache(TEMPLATE_CACHE_SIZE),
+				rendering.NewDirectoryLoader(TEMPLATE_DIR),
 				leftDelimiter,
 				rightDelimiter,
 				leftLoopVariableDelimiter,
@@ -83,6 +91,8 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func getRenderHandler(
+	cache *rendering.Cache,
+	loader rendering.Loader,
 	leftDelimiter string,
 	rightDelimiter string,
 	leftLoopVariableDelimiter string,
@@ -92,8 +102,14 @@ func getRenderHandler(
 ) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		type Params struct {
-			Variables map[string]interface{}
+			Variables json.RawMessage
 			Template  string
+			// escaping of the inserted values, none when empty and chosen from the template extension when auto
+			Escaping string
+			// structured output mode, none when empty and chosen from the template extension when auto
+			Structured string
+			// order of the entries of the maps iterated by the loop blocks, sorted when empty or insertion as in the request
+			LoopOrder string
 		}
 		params := &Params{}
 
@@ -103,25 +119,101 @@ func getRenderHandler(
 			return
 		}
 
+		variables, keyOrder, err := parseVariables(params.Variables, params.LoopOrder)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		content, err := os.ReadFile(TEMPLATE_DIR + "/" + params.Template)
 		if err != nil {
 			panic(err)
 		}
 
-		rendered := rendering.Render(
-			string(content),
-			params.Variables,
-			leftDelimiter,
-			rightDelimiter,
-			leftLoopVariableDelimiter,
-			rightLoopVariableDelimiter,
-			leftLoopBlockDelimiter,
-			rightLoopBlockDelimiter,
-			false,
-		)
-
-		w.Write([]byte(rendered))
+		escaping := params.Escaping
+		if escaping == rendering.AutoEscaping {
+			escaping = rendering.EscapingFromExtension(params.Template)
+		}
+		structured := params.Structured
+		if structured == rendering.AutoStructured {
+			structured = rendering.StructuredFromExtension(params.Template)
+		}
+
+		compiled, err := cache.Compile(string(content), rendering.Options{
+			Name:                       params.Template,
+			LeftDelimiter:              leftDelimiter,
+			RightDelimiter:             rightDelimiter,
+			LeftLoopVariableDelimiter:  leftLoopVariableDelimiter,
+			RightLoopVariableDelimiter: rightLoopVariableDelimiter,
+			LeftLoopBlockDelimiter:     leftLoopBlockDelimiter,
+			RightLoopBlockDelimiter:    rightLoopBlockDelimiter,
+			Escaping:                   escaping,
+			Structured:                 structured,
+			Loader:                     loader,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		// the output is sent as it is rendered
+		body := &bodyWriter{w: w}
+		writer := bufio.NewWriter(body)
+		_, err = compiled.ExecuteTo(writer, variables, keyOrder)
+		if err == nil {
+			err = writer.Flush()
+		}
+		if err != nil {
+			if !body.started {
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+			// the status was sent with the beginning of the output
+			log.Println(err)
+		}
+	}
+}
+
+// Variables of a render request, along with the order of the keys of their objects in the request when the loop order is insertion
+func parseVariables(raw json.RawMessage, loopOrder string) (map[string]interface{}, rendering.KeyOrder, error) {
+	if len(raw) == 0 {
+		return nil, nil, nil
+	}
+
+	var decoded interface{}
+	var keyOrder rendering.KeyOrder
+	var err error
+	switch loopOrder {
+	case "", rendering.SortedKeyOrder:
+		decoded, err = parsing.ParseJSON(raw)
+	case rendering.InsertionKeyOrder:
+		var order *rendering.InsertionOrder
+		decoded, order, err = parsing.ParseJSONOrdered(raw)
+		keyOrder = order
+	default:
+		return nil, nil, &rendering.InvalidOptionError{Option: "loop order", Value: loopOrder, Reason: "must be sorted or insertion"}
+	}
+	if err != nil {
+		return nil, nil, err
 	}
+
+	variables, ok := decoded.(map[string]interface{})
+	if !ok && decoded != nil {
+		return nil, nil, errors.New("variables must be an object")
+	}
+
+	return variables, keyOrder, nil
+}
+
+// Writer of a response body, recording whether the response was started
+type bodyWriter struct {
+	w       io.Writer
+	started bool
+}
+
+func (b *bodyWriter) Write(p []byte) (int, error) {
+	b.started = true
+	return b.w.Write(p)
 }
 
 func uploadFile(w http.ResponseWriter, r *http.Request) {